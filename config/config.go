@@ -16,8 +16,38 @@ import (
 )
 
 type SessionInfo struct {
-	SessionKey string `yaml:"sessionKey"`
-	OrgID      string `yaml:"orgID"`
+	SessionKey    string `yaml:"sessionKey"`
+	OrgID         string `yaml:"orgID"`
+	OrgName       string `yaml:"orgName"`       // 按组织名称选择组织
+	OrgTier       string `yaml:"orgTier"`       // 按 rate_limit_tier 选择组织
+	OrgCapability string `yaml:"orgCapability"` // 按 capabilities 选择组织
+	AllOrgs       bool   `yaml:"allOrgs"`       // 为每个可见组织展开一个独立的 session
+	Timezone      string `yaml:"timezone"`      // 覆盖全局时区
+	Locale        string `yaml:"locale"`        // 覆盖全局 Accept-Language
+}
+
+// OrgPolicy 返回 session 的组织选择策略描述，未配置时为空
+func (s SessionInfo) OrgPolicy() string {
+	var parts []string
+	if s.OrgName != "" {
+		parts = append(parts, "name="+s.OrgName)
+	}
+	if s.OrgTier != "" {
+		parts = append(parts, "tier="+s.OrgTier)
+	}
+	if s.OrgCapability != "" {
+		parts = append(parts, "capability="+s.OrgCapability)
+	}
+	return strings.Join(parts, ";")
+}
+
+// ImageProcessing 控制上传前对图片的缩放和重新编码
+type ImageProcessing struct {
+	Enabled   bool   `yaml:"enabled"`
+	MaxEdge   int    `yaml:"maxEdge"`   // 最长边像素上限
+	MaxPixels int    `yaml:"maxPixels"` // 总像素上限
+	Format    string `yaml:"format"`    // jpeg、png、webp，为空时保持原格式
+	Quality   int    `yaml:"quality"`   // JPEG 质量 1-100
 }
 
 type SessionRagen struct {
@@ -26,19 +56,45 @@ type SessionRagen struct {
 }
 
 type Config struct {
-	Sessions               []SessionInfo `yaml:"sessions"`
-	Address                string        `yaml:"address"`
-	APIKey                 string        `yaml:"apiKey"`
-	Proxy                  string        `yaml:"proxy"`
-	BaseURL                string        `yaml:"baseURL"`  // 新增：自定义Claude API基础域名
-	ChatDelete             bool          `yaml:"chatDelete"`
-	MaxChatHistoryLength   int           `yaml:"maxChatHistoryLength"`
-	RetryCount             int           `yaml:"retryCount"`
-	NoRolePrefix           bool          `yaml:"noRolePrefix"`
-	PromptDisableArtifacts bool          `yaml:"promptDisableArtifacts"`
-	EnableMirrorApi        bool          `yaml:"enableMirrorApi"`
-	MirrorApiPrefix        string        `yaml:"mirrorApiPrefix"`
-	RwMutx                 sync.RWMutex  `yaml:"-"` // 不从YAML加载
+	Sessions                []SessionInfo              `yaml:"sessions"`
+	Address                 string                     `yaml:"address"`
+	APIKey                  string                     `yaml:"apiKey"`
+	APIKeys                 []APIKeyConfig             `yaml:"apiKeys"` // 多个 API 密钥及各自的设置
+	Proxy                   string                     `yaml:"proxy"`
+	BaseURL                 string                     `yaml:"baseURL"` // 新增：自定义Claude API基础域名
+	ChatDelete              bool                       `yaml:"chatDelete"`
+	MaxChatHistoryLength    int                        `yaml:"maxChatHistoryLength"`
+	MaxChatHistoryTokens    int                        `yaml:"maxChatHistoryTokens"`    // 按估算 token 数判断超长上下文，优先于字符数
+	ContextStrategy         string                     `yaml:"contextStrategy"`         // 超长上下文处理策略：file、recent、compact、none
+	ContextKeepTurns        int                        `yaml:"contextKeepTurns"`        // recent 策略保留在提示词中的最近轮数
+	ContextAttachmentTokens int                        `yaml:"contextAttachmentTokens"` // 单个上下文附件的估算 token 上限
+	CompactModel            string                     `yaml:"compactModel"`            // compact 策略用于总结历史的模型
+	JSONRepairAttempts      int                        `yaml:"jsonRepairAttempts"`      // JSON 模式回答无效时要求修正的次数，小于0时不修正
+	RetryCount              int                        `yaml:"retryCount"`
+	MaxChoices              int                        `yaml:"maxChoices"`        // 请求参数 n 的上限
+	SpreadChoices           bool                       `yaml:"spreadChoices"`     // n > 1 时每个选项使用 session 池中不同的 session
+	ArtifactMode            string                     `yaml:"artifactMode"`      // artifact 返回方式：markdown、structured、tool_calls、resource
+	ArtifactTTL             int                        `yaml:"artifactTTL"`       // /v1/artifacts 保留 artifact 的时间（分钟）
+	ResponseTTL             int                        `yaml:"responseTTL"`       // /v1/responses 保存响应的时间（分钟）
+	ThinkingMinEffort       string                     `yaml:"thinkingMinEffort"` // 开启扩展思考的最低 reasoning_effort
+	Timezone                string                     `yaml:"timezone"`          // 发送给 claude.ai 的 IANA 时区
+	Locale                  string                     `yaml:"locale"`            // 发送给 claude.ai 的 Accept-Language
+	NoRolePrefix            bool                       `yaml:"noRolePrefix"`
+	PromptDisableArtifacts  bool                       `yaml:"promptDisableArtifacts"`
+	RoleSpoofing            string                     `yaml:"roleSpoofing"` // 消息内容中角色标记的处理：off、escape、wrap、reject
+	EnableMirrorApi         bool                       `yaml:"enableMirrorApi"`
+	MirrorApiPrefix         string                     `yaml:"mirrorApiPrefix"`
+	StateFile               string                     `yaml:"stateFile"`    // 持久化 session 组织信息的状态文件
+	MaxFileSize             int64                      `yaml:"maxFileSize"`  // 远程文件下载大小上限（字节）
+	FetchTimeout            int                        `yaml:"fetchTimeout"` // 远程文件下载超时（秒）
+	FileCacheTTL            int                        `yaml:"fileCacheTTL"` // 已上传文件复用缓存有效期（分钟），小于0时禁用
+	ImageProcessing         ImageProcessing            `yaml:"imageProcessing"`
+	ModelImageProcessing    map[string]ImageProcessing `yaml:"modelImageProcessing"` // 按模型覆盖图片处理配置
+	PromptTemplates         map[string]PromptTemplate  `yaml:"promptTemplates"`      // 命名的提示词模板，default 为全局默认
+	ModelPromptTemplates    map[string]string          `yaml:"modelPromptTemplates"` // 模型使用的提示词模板名称
+	Styles                  map[string]StyleConfig     `yaml:"styles"`               // 代理定义的写作风格
+	ModelAliases            map[string]ModelAlias      `yaml:"modelAliases"`         // 模型别名
+	RwMutx                  sync.RWMutex               `yaml:"-"`                    // 不从YAML加载
 }
 
 // 解析 SESSION 格式的环境变量
@@ -59,7 +115,10 @@ func parseSessionEnv(envValue string) (int, []SessionInfo) {
 			SessionKey: parts[0],
 		}
 
-		if len(parts) > 1 {
+		if len(parts) > 1 && parts[1] == "*" {
+			// key:* 表示为该 session 的每个组织展开一个 session
+			session.AllOrgs = true
+		} else if len(parts) > 1 {
 			session.OrgID = parts[1]
 		} else if len(parts) == 1 {
 			session.OrgID = ""
@@ -83,17 +142,64 @@ func (c *Config) GetSessionForModel(idx int) (SessionInfo, error) {
 	return c.Sessions[idx], nil
 }
 
-func (c *Config) SetSessionOrgID(sessionKey, orgID string) {
+// SetSessionOrgID 把 session key 下组织为 oldOrgID 的第一个 session 改为 orgID，
+// 展开到多个组织的 session 只更新被清除组织的那一个
+func (c *Config) SetSessionOrgID(sessionKey, oldOrgID, orgID string) {
 	c.RwMutx.Lock()
 	defer c.RwMutx.Unlock()
 	for i, session := range c.Sessions {
-		if session.SessionKey == sessionKey {
+		if session.SessionKey == sessionKey && session.OrgID == oldOrgID {
 			logger.Info(fmt.Sprintf("Setting OrgID for session %s to %s", sessionKey, orgID))
 			c.Sessions[i].OrgID = orgID
 			return
 		}
 	}
 }
+
+// ReplaceSessions 替换 session 列表，用于多组织 session 的展开
+func (c *Config) ReplaceSessions(sessions []SessionInfo) {
+	c.RwMutx.Lock()
+	defer c.RwMutx.Unlock()
+	c.Sessions = sessions
+}
+
+// ImageProcessingFor 返回模型使用的图片处理配置，-think 变体沿用基础模型的配置
+func (c *Config) ImageProcessingFor(model string) ImageProcessing {
+	if opts, ok := c.ModelImageProcessing[model]; ok {
+		return opts
+	}
+	if opts, ok := c.ModelImageProcessing[strings.TrimSuffix(model, "-think")]; ok {
+		return opts
+	}
+	return c.ImageProcessing
+}
+
+// InvalidateSessionOrgID 清除上游判定为无效的组织 ID，下次请求时重新解析
+func (c *Config) InvalidateSessionOrgID(sessionKey, orgID string) {
+	c.RwMutx.Lock()
+	for i, session := range c.Sessions {
+		if session.SessionKey == sessionKey && session.OrgID == orgID {
+			logger.Info(fmt.Sprintf("Invalidating OrgID %s for session %s", orgID, sessionKey))
+			c.Sessions[i].OrgID = ""
+		}
+	}
+	c.RwMutx.Unlock()
+	State.Delete(sessionKey, orgID)
+}
+
+// applySessionState 用状态文件中缓存的组织 ID 填充未配置 OrgID 的 session
+func (c *Config) applySessionState(store *StateStore) {
+	c.RwMutx.Lock()
+	defer c.RwMutx.Unlock()
+	for i, session := range c.Sessions {
+		if session.OrgID != "" {
+			continue
+		}
+		if state, ok := store.Resolved(session.SessionKey, session.OrgPolicy()); ok {
+			c.Sessions[i].OrgID = state.OrgID
+		}
+	}
+}
 func (sr *SessionRagen) NextIndex() int {
 	sr.Mutex.Lock()
 	defer sr.Mutex.Unlock()
@@ -144,15 +250,7 @@ func loadConfigFromYAML(configPath string) (*Config, error) {
 	// 设置读写锁（不从YAML加载）
 	config.RwMutx = sync.RWMutex{}
 
-	// 如果地址为空，使用默认值
-	if config.Address == "" {
-		config.Address = "0.0.0.0:8080"
-	}
-	
-	// 如果BaseURL为空，使用默认值
-	if config.BaseURL == "" {
-		config.BaseURL = "https://claude.ai"
-	}
+	config.applyDefaults()
 
 	return &config, nil
 }
@@ -164,6 +262,19 @@ func loadConfigFromEnv() *Config {
 		maxChatHistoryLength = 10000 // 默认值
 	}
 	retryCount, sessions := parseSessionEnv(os.Getenv("SESSIONS"))
+	maxChatHistoryTokens, _ := strconv.Atoi(os.Getenv("MAX_CHAT_HISTORY_TOKENS"))
+	contextKeepTurns, _ := strconv.Atoi(os.Getenv("CONTEXT_KEEP_TURNS"))
+	contextAttachmentTokens, _ := strconv.Atoi(os.Getenv("CONTEXT_ATTACHMENT_TOKENS"))
+	jsonRepairAttempts, _ := strconv.Atoi(os.Getenv("JSON_REPAIR_ATTEMPTS"))
+	maxChoices, _ := strconv.Atoi(os.Getenv("MAX_CHOICES"))
+	artifactTTL, _ := strconv.Atoi(os.Getenv("ARTIFACT_TTL"))
+	responseTTL, _ := strconv.Atoi(os.Getenv("RESPONSE_TTL"))
+	maxFileSize, _ := strconv.ParseInt(os.Getenv("MAX_FILE_SIZE"), 10, 64)
+	fetchTimeout, _ := strconv.Atoi(os.Getenv("FETCH_TIMEOUT"))
+	fileCacheTTL, _ := strconv.Atoi(os.Getenv("FILE_CACHE_TTL"))
+	imageMaxEdge, _ := strconv.Atoi(os.Getenv("IMAGE_MAX_EDGE"))
+	imageMaxPixels, _ := strconv.Atoi(os.Getenv("IMAGE_MAX_PIXELS"))
+	imageQuality, _ := strconv.Atoi(os.Getenv("IMAGE_QUALITY"))
 	config := &Config{
 		// 解析 SESSIONS 环境变量
 		Sessions: sessions,
@@ -180,31 +291,172 @@ func loadConfigFromEnv() *Config {
 		ChatDelete: os.Getenv("CHAT_DELETE") != "false",
 		// 设置最大聊天历史长度
 		MaxChatHistoryLength: maxChatHistoryLength,
+		// 设置超长上下文处理
+		MaxChatHistoryTokens:    maxChatHistoryTokens,
+		ContextStrategy:         os.Getenv("CONTEXT_STRATEGY"),
+		ContextKeepTurns:        contextKeepTurns,
+		ContextAttachmentTokens: contextAttachmentTokens,
+		CompactModel:            os.Getenv("COMPACT_MODEL"),
+		// 设置 JSON 模式修正次数
+		JSONRepairAttempts: jsonRepairAttempts,
 		// 设置重试次数
 		RetryCount: retryCount,
+		// 设置多选项上限和 session 分配
+		MaxChoices:    maxChoices,
+		SpreadChoices: os.Getenv("SPREAD_CHOICES") == "true",
+		// 设置 artifact 返回方式
+		ArtifactMode: os.Getenv("ARTIFACT_MODE"),
+		ArtifactTTL:  artifactTTL,
+		// 设置 /v1/responses 保存响应的时间
+		ResponseTTL: responseTTL,
+		// 设置开启扩展思考的最低 reasoning_effort
+		ThinkingMinEffort: os.Getenv("THINKING_MIN_EFFORT"),
+		// 设置时区和语言
+		Timezone: os.Getenv("TIMEZONE"),
+		Locale:   os.Getenv("LOCALE"),
 		// 设置是否使用角色前缀
 		NoRolePrefix: os.Getenv("NO_ROLE_PREFIX") == "true",
 		// 设置是否使用提示词禁用artifacts
 		PromptDisableArtifacts: os.Getenv("PROMPT_DISABLE_ARTIFACTS") == "true",
+		// 设置角色标记伪造防护策略
+		RoleSpoofing: os.Getenv("ROLE_SPOOFING"),
 		// 设置是否启用镜像API
 		EnableMirrorApi: os.Getenv("ENABLE_MIRROR_API") == "true",
 		// 设置镜像API前缀
 		MirrorApiPrefix: os.Getenv("MIRROR_API_PREFIX"),
+		// 设置状态文件路径
+		StateFile: os.Getenv("STATE_FILE"),
+		// 设置远程文件大小上限
+		MaxFileSize: maxFileSize,
+		// 设置远程文件下载超时
+		FetchTimeout: fetchTimeout,
+		// 设置已上传文件缓存有效期
+		FileCacheTTL: fileCacheTTL,
+		// 设置图片预处理
+		ImageProcessing: ImageProcessing{
+			Enabled:   os.Getenv("IMAGE_PROCESSING") == "true",
+			MaxEdge:   imageMaxEdge,
+			MaxPixels: imageMaxPixels,
+			Format:    os.Getenv("IMAGE_FORMAT"),
+			Quality:   imageQuality,
+		},
 		// 设置读写锁
 		RwMutx: sync.RWMutex{},
 	}
 
+	config.applyDefaults()
+
+	return config
+}
+
+// applyDefaults 为未设置的配置项填充默认值
+func (c *Config) applyDefaults() {
 	// 如果地址为空，使用默认值
-	if config.Address == "" {
-		config.Address = "0.0.0.0:8080"
+	if c.Address == "" {
+		c.Address = "0.0.0.0:8080"
 	}
-	
+
 	// 如果BaseURL为空，使用默认值
-	if config.BaseURL == "" {
-		config.BaseURL = "https://claude.ai"
+	if c.BaseURL == "" {
+		c.BaseURL = "https://claude.ai"
+	}
+
+	// 如果状态文件为空，使用默认值
+	if c.StateFile == "" {
+		c.StateFile = "state.json"
+	}
+
+	if c.ContextStrategy == "" {
+		c.ContextStrategy = "file"
+	}
+	if c.ContextKeepTurns <= 0 {
+		c.ContextKeepTurns = 4
+	}
+	if c.ContextAttachmentTokens <= 0 {
+		c.ContextAttachmentTokens = 100000
+	}
+	switch c.RoleSpoofing {
+	case "off", "escape", "wrap", "reject":
+	case "":
+		c.RoleSpoofing = "off"
+	default:
+		// 拒绝未知的策略，避免防护被静默替换
+		logger.Fatal(fmt.Sprintf("Unknown roleSpoofing %q, expected off, escape, wrap or reject", c.RoleSpoofing))
+	}
+	if c.CompactModel == "" {
+		c.CompactModel = "claude-3-5-haiku-20241022"
+	}
+	if c.JSONRepairAttempts == 0 {
+		c.JSONRepairAttempts = 2
+	} else if c.JSONRepairAttempts < 0 {
+		c.JSONRepairAttempts = 0
+	}
+	if c.MaxChoices <= 0 {
+		c.MaxChoices = 4
+	}
+	switch c.ArtifactMode {
+	case "markdown", "structured", "tool_calls", "resource":
+	case "":
+		c.ArtifactMode = "markdown"
+	default:
+		logger.Error(fmt.Sprintf("Unknown artifactMode %q, using markdown", c.ArtifactMode))
+		c.ArtifactMode = "markdown"
+	}
+	if c.ArtifactTTL <= 0 {
+		c.ArtifactTTL = 60
+	}
+	if c.ResponseTTL <= 0 {
+		c.ResponseTTL = 60
+	}
+	if c.Timezone == "" {
+		c.Timezone = "America/Los_Angeles"
+	} else if err := ValidateTimezone(c.Timezone); err != nil {
+		logger.Error(fmt.Sprintf("%v, using America/Los_Angeles", err))
+		c.Timezone = "America/Los_Angeles"
+	}
+	if c.Locale == "" {
+		c.Locale = "zh-CN,zh;q=0.9"
+	} else if err := ValidateAcceptLanguage(c.Locale); err != nil {
+		logger.Error(fmt.Sprintf("Invalid locale %q: %v, using zh-CN,zh;q=0.9", c.Locale, err))
+		c.Locale = "zh-CN,zh;q=0.9"
+	}
+	for i, session := range c.Sessions {
+		if session.Timezone != "" && ValidateTimezone(session.Timezone) != nil {
+			logger.Error(fmt.Sprintf("Invalid timezone %q of session %d, using %s", session.Timezone, i, c.Timezone))
+			c.Sessions[i].Timezone = ""
+		}
+		if session.Locale != "" && ValidateAcceptLanguage(session.Locale) != nil {
+			logger.Error(fmt.Sprintf("Invalid locale %q of session %d, using %s", session.Locale, i, c.Locale))
+			c.Sessions[i].Locale = ""
+		}
+	}
+	switch c.ThinkingMinEffort {
+	case "minimal", "low", "medium", "high":
+	case "":
+		c.ThinkingMinEffort = "low"
+	default:
+		logger.Error(fmt.Sprintf("Unknown thinkingMinEffort %q, using low", c.ThinkingMinEffort))
+		c.ThinkingMinEffort = "low"
+	}
+
+	if c.MaxFileSize <= 0 {
+		c.MaxFileSize = 20 * 1024 * 1024
+	}
+	if c.FetchTimeout <= 0 {
+		c.FetchTimeout = 30
+	}
+	if c.FileCacheTTL == 0 {
+		c.FileCacheTTL = 60
+	}
+	if c.ImageProcessing.MaxEdge == 0 {
+		c.ImageProcessing.MaxEdge = 1568
+	}
+	if c.ImageProcessing.MaxPixels == 0 {
+		c.ImageProcessing.MaxPixels = 1568 * 1568
+	}
+	if c.ImageProcessing.Quality == 0 {
+		c.ImageProcessing.Quality = 85
 	}
-	
-	return config
 }
 
 // 加载配置
@@ -228,6 +480,7 @@ func LoadConfig() *Config {
 
 var ConfigInstance *Config
 var Sr *SessionRagen
+var State *StateStore
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
@@ -238,6 +491,8 @@ func init() {
 		Mutex: sync.Mutex{},
 	}
 	ConfigInstance = LoadConfig()
+	State = LoadState(ConfigInstance.StateFile)
+	ConfigInstance.applySessionState(State)
 	logger.Info("Loaded config:")
 	logger.Info(fmt.Sprintf("Max Retry count: %d", ConfigInstance.RetryCount))
 	for _, session := range ConfigInstance.Sessions {
@@ -245,12 +500,41 @@ func init() {
 	}
 	logger.Info(fmt.Sprintf("Address: %s", ConfigInstance.Address))
 	logger.Info(fmt.Sprintf("APIKey: %s", ConfigInstance.APIKey))
+	for _, entry := range ConfigInstance.APIKeys {
+		logger.Info(fmt.Sprintf("APIKey %s: promptTemplate=%s", entry.Name, entry.PromptTemplate))
+	}
+	for name := range ConfigInstance.PromptTemplates {
+		logger.Info(fmt.Sprintf("PromptTemplate: %s", name))
+	}
+	for name := range ConfigInstance.Styles {
+		logger.Info(fmt.Sprintf("Style: %s", name))
+	}
+	for name, alias := range ConfigInstance.ModelAliases {
+		logger.Info(fmt.Sprintf("ModelAlias: %s -> %s (style %s)", name, alias.Model, alias.Style))
+	}
 	logger.Info(fmt.Sprintf("Proxy: %s", ConfigInstance.Proxy))
 	logger.Info(fmt.Sprintf("BaseURL: %s", ConfigInstance.BaseURL))
 	logger.Info(fmt.Sprintf("ChatDelete: %t", ConfigInstance.ChatDelete))
 	logger.Info(fmt.Sprintf("MaxChatHistoryLength: %d", ConfigInstance.MaxChatHistoryLength))
+	logger.Info(fmt.Sprintf("MaxChatHistoryTokens: %d", ConfigInstance.MaxChatHistoryTokens))
+	logger.Info(fmt.Sprintf("ContextStrategy: %s (keep turns %d, attachment tokens %d)", ConfigInstance.ContextStrategy, ConfigInstance.ContextKeepTurns, ConfigInstance.ContextAttachmentTokens))
+	if ConfigInstance.ContextStrategy == "compact" {
+		logger.Info(fmt.Sprintf("CompactModel: %s", ConfigInstance.CompactModel))
+	}
 	logger.Info(fmt.Sprintf("NoRolePrefix: %t", ConfigInstance.NoRolePrefix))
 	logger.Info(fmt.Sprintf("PromptDisableArtifacts: %t", ConfigInstance.PromptDisableArtifacts))
+	logger.Info(fmt.Sprintf("RoleSpoofing: %s", ConfigInstance.RoleSpoofing))
+	logger.Info(fmt.Sprintf("JSONRepairAttempts: %d", ConfigInstance.JSONRepairAttempts))
+	logger.Info(fmt.Sprintf("MaxChoices: %d (spread across sessions: %t)", ConfigInstance.MaxChoices, ConfigInstance.SpreadChoices))
+	logger.Info(fmt.Sprintf("ArtifactMode: %s (ttl %d minutes)", ConfigInstance.ArtifactMode, ConfigInstance.ArtifactTTL))
+	logger.Info(fmt.Sprintf("ResponseTTL: %d minutes", ConfigInstance.ResponseTTL))
+	logger.Info(fmt.Sprintf("ThinkingMinEffort: %s", ConfigInstance.ThinkingMinEffort))
+	logger.Info(fmt.Sprintf("Timezone: %s, Locale: %s", ConfigInstance.Timezone, ConfigInstance.Locale))
 	logger.Info(fmt.Sprintf("EnableMirrorApi: %t", ConfigInstance.EnableMirrorApi))
 	logger.Info(fmt.Sprintf("MirrorApiPrefix: %s", ConfigInstance.MirrorApiPrefix))
+	logger.Info(fmt.Sprintf("StateFile: %s", ConfigInstance.StateFile))
+	logger.Info(fmt.Sprintf("MaxFileSize: %d", ConfigInstance.MaxFileSize))
+	logger.Info(fmt.Sprintf("FetchTimeout: %d", ConfigInstance.FetchTimeout))
+	logger.Info(fmt.Sprintf("FileCacheTTL: %d", ConfigInstance.FileCacheTTL))
+	logger.Info(fmt.Sprintf("ImageProcessing: %+v", ConfigInstance.ImageProcessing))
 }
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	// 内置 IANA 时区数据库，容器中没有 zoneinfo 时也能校验时区
+	_ "time/tzdata"
+)
+
+// languageTag matches a BCP 47 language tag such as en-US
+var languageTag = regexp.MustCompile(`^[A-Za-z]{2,8}(-[A-Za-z0-9]{1,8})*$`)
+
+// ValidateTimezone 检查 IANA 时区名称，如 Europe/Berlin
+func ValidateTimezone(name string) error {
+	if name == "" || name == "Local" {
+		return fmt.Errorf("invalid timezone %q", name)
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf("invalid timezone %q", name)
+	}
+	return nil
+}
+
+// ValidateAcceptLanguage 检查 Accept-Language 值，如 en-US,en;q=0.9
+func ValidateAcceptLanguage(value string) error {
+	if len(value) > 256 {
+		return fmt.Errorf("accept-language too long")
+	}
+	for _, part := range strings.Split(value, ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag != "*" && !languageTag.MatchString(tag) {
+			return fmt.Errorf("invalid language %q", tag)
+		}
+	}
+	return nil
+}
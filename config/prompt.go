@@ -0,0 +1,75 @@
+package config
+
+import "strings"
+
+// PromptTemplate 使用 Go text/template 控制提示词的渲染，为空的字段使用内置默认值
+type PromptTemplate struct {
+	// 单条消息，可用 .Role .Label .Name .Content .Index
+	Message string `yaml:"message"`
+	// 每个文本片段后追加的分隔符
+	Separator *string `yaml:"separator"`
+	// 角色显示名称，如 user: Human，设置 noRolePrefix 时为空
+	RoleLabels map[string]string `yaml:"roleLabels"`
+	// 图片和文件在消息中的引用，可用 .Kind(image/file) .Index .FileName，默认不引用
+	FileReference string `yaml:"fileReference"`
+	// assistant 消息中的 tool_calls，可用 .ID .Name .Arguments
+	ToolCall string `yaml:"toolCall"`
+	// tool/function 角色消息的内容，可用 .Name .ToolCallID .Content
+	ToolResult string `yaml:"toolResult"`
+	// promptDisableArtifacts 开启时置于提示词开头的说明
+	DisableArtifacts string `yaml:"disableArtifacts"`
+	// 历史转为附件时的说明，可用 .Files
+	BigContext string `yaml:"bigContext"`
+	// recent 策略中较早历史的说明，可用 .Files
+	RecentContext string `yaml:"recentContext"`
+	// compact 策略的摘要，可用 .Turns .Summary
+	CompactedContext string `yaml:"compactedContext"`
+}
+
+// APIKeyConfig 是一个可访问服务的 API 密钥及其专属设置
+type APIKeyConfig struct {
+	Key            string       `yaml:"key"`
+	Name           string       `yaml:"name"`
+	PromptTemplate string       `yaml:"promptTemplate"` // promptTemplates 中的模板名称
+	Tools          ToolSettings `yaml:"tools"`          // 该密钥默认启用的 claude.ai 工具
+	Admin          bool         `yaml:"admin"`          // 允许访问 /admin 管理接口
+}
+
+// ToolSettings 控制 claude.ai 的工具，未设置的项保持启用
+type ToolSettings struct {
+	WebSearch *bool `yaml:"webSearch"`
+	Artifacts *bool `yaml:"artifacts"`
+	REPL      *bool `yaml:"repl"`
+}
+
+// FindAPIKey 查找 API 密钥，apiKey 和 apiKeys 中的密钥都有效
+func (c *Config) FindAPIKey(key string) (APIKeyConfig, bool) {
+	if key == "" {
+		return APIKeyConfig{}, false
+	}
+	if c.APIKey != "" && key == c.APIKey {
+		// 单一的 apiKey 同时是管理密钥
+		return APIKeyConfig{Key: key, Name: "default", Admin: true}, true
+	}
+	for _, entry := range c.APIKeys {
+		if entry.Key == key {
+			return entry, true
+		}
+	}
+	return APIKeyConfig{}, false
+}
+
+// PromptTemplateFor 依次按 API 密钥、模型和 default 选择模板，返回模板名称，未配置时返回空
+func (c *Config) PromptTemplateFor(keyTemplate, model string) (string, PromptTemplate) {
+	candidates := []string{keyTemplate, c.ModelPromptTemplates[model],
+		c.ModelPromptTemplates[strings.TrimSuffix(model, "-think")], "default"}
+	for _, name := range candidates {
+		if name == "" {
+			continue
+		}
+		if tmpl, ok := c.PromptTemplates[name]; ok {
+			return name, tmpl
+		}
+	}
+	return "", PromptTemplate{}
+}
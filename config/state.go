@@ -0,0 +1,164 @@
+package config
+
+import (
+	"claude2api/logger"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionState 是持久化到本地状态文件中的 session 元数据
+type SessionState struct {
+	OrgID         string    `json:"orgID"`
+	Tier          string    `json:"tier,omitempty"`
+	Email         string    `json:"email,omitempty"`
+	Policy        string    `json:"policy,omitempty"` // 解析组织时使用的选择策略，策略变化后缓存失效
+	LastValidated time.Time `json:"lastValidated"`
+}
+
+// StateStore 以 session key 的哈希和组织 ID 为键保存已解析的组织信息，避免每次重启都重新请求 /api/organizations。
+// 同一 session key 展开到多个组织时每个组织各有一条记录。
+type StateStore struct {
+	path     string
+	mutex    sync.Mutex
+	Sessions map[string]SessionState `json:"sessions"`
+}
+
+// HashSessionKey 返回 session key 的 sha256 摘要，状态文件中不保存明文 session key
+func HashSessionKey(sessionKey string) string {
+	sum := sha256.Sum256([]byte(sessionKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// stateKey 返回 session key 和组织对应的状态键
+func stateKey(sessionKey, orgID string) string {
+	return HashSessionKey(sessionKey) + ":" + orgID
+}
+
+// LoadState 从状态文件加载 session 元数据，path 为空时只在内存中保存
+func LoadState(path string) *StateStore {
+	store := &StateStore{
+		path:     path,
+		Sessions: map[string]SessionState{},
+	}
+	if path == "" {
+		return store
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error(fmt.Sprintf("Failed to read state file %s: %v", path, err))
+		}
+		return store
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		logger.Error(fmt.Sprintf("Failed to parse state file %s: %v", path, err))
+		store.Sessions = map[string]SessionState{}
+		return store
+	}
+	if store.Sessions == nil {
+		store.Sessions = map[string]SessionState{}
+	}
+	// 旧版状态文件只以 session key 的哈希为键
+	for key, state := range store.Sessions {
+		if !strings.Contains(key, ":") {
+			delete(store.Sessions, key)
+			store.Sessions[key+":"+state.OrgID] = state
+		}
+	}
+	logger.Info(fmt.Sprintf("Loaded %d session states from %s", len(store.Sessions), path))
+	return store
+}
+
+// Get 返回 session 在指定组织下的持久化状态
+func (s *StateStore) Get(sessionKey, orgID string) (SessionState, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, ok := s.Sessions[stateKey(sessionKey, orgID)]
+	return state, ok
+}
+
+// Resolved 返回按 policy 为 session 解析过的组织中最近验证的一条
+func (s *StateStore) Resolved(sessionKey, policy string) (SessionState, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	prefix := HashSessionKey(sessionKey) + ":"
+	var found SessionState
+	ok := false
+	for key, state := range s.Sessions {
+		if !strings.HasPrefix(key, prefix) || state.OrgID == "" || state.Policy != policy {
+			continue
+		}
+		if !ok || state.LastValidated.After(found.LastValidated) {
+			found = state
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+// Put 保存 session 状态并写回状态文件
+func (s *StateStore) Put(sessionKey string, state SessionState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Sessions[stateKey(sessionKey, state.OrgID)] = state
+	s.save()
+}
+
+// Touch 更新 session 在指定组织下的最后验证时间，interval 内已验证过的不重复写文件
+func (s *StateStore) Touch(sessionKey, orgID string, interval time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := stateKey(sessionKey, orgID)
+	state, ok := s.Sessions[key]
+	if !ok || time.Since(state.LastValidated) < interval {
+		return
+	}
+	state.LastValidated = time.Now()
+	s.Sessions[key] = state
+	s.save()
+}
+
+// Delete 删除 session 在指定组织下的持久化状态
+func (s *StateStore) Delete(sessionKey, orgID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := stateKey(sessionKey, orgID)
+	if _, ok := s.Sessions[key]; !ok {
+		return
+	}
+	delete(s.Sessions, key)
+	s.save()
+}
+
+// save 先写临时文件再重命名，避免进程中断时损坏状态文件，调用方需持有锁
+func (s *StateStore) save() {
+	if s.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to marshal state: %v", err))
+		return
+	}
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			logger.Error(fmt.Sprintf("Failed to create state directory %s: %v", dir, err))
+			return
+		}
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		logger.Error(fmt.Sprintf("Failed to write state file %s: %v", tmpPath, err))
+		return
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		logger.Error(fmt.Sprintf("Failed to replace state file %s: %v", s.path, err))
+	}
+}
@@ -0,0 +1,25 @@
+package config
+
+// StyleConfig 是代理定义的写作风格，作为自定义 personalized style 发送
+type StyleConfig struct {
+	Name    string `yaml:"name" json:"name"`       // 显示名称，为空时使用键名
+	Prompt  string `yaml:"prompt" json:"prompt"`   // 风格说明
+	Summary string `yaml:"summary" json:"summary"` // 简短描述
+}
+
+// ModelAlias 把别名映射到模型并附带默认设置，请求参数优先于别名的设置
+type ModelAlias struct {
+	Model           string `yaml:"model"`
+	Style           string `yaml:"style"`
+	ReasoningEffort string `yaml:"reasoningEffort"`
+	Project         string `yaml:"project"` // claude.ai 项目的 uuid 或名称
+}
+
+// ModelAlias 返回模型别名的配置
+func (c *Config) ModelAlias(name string) (ModelAlias, bool) {
+	alias, ok := c.ModelAliases[name]
+	if !ok || alias.Model == "" {
+		return ModelAlias{}, false
+	}
+	return alias, true
+}
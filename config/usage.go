@@ -0,0 +1,91 @@
+package config
+
+import (
+	"sync"
+	"time"
+)
+
+// claude.ai message_limit 事件中的额度状态
+const (
+	UsageWithinLimit      = "within_limit"
+	UsageApproachingLimit = "approaching_limit"
+	UsageExceededLimit    = "exceeded_limit"
+)
+
+// 选择 session 时的优先级，数值越小越优先
+const (
+	UsageRankAvailable = iota
+	UsageRankApproaching
+	UsageRankExceeded
+)
+
+// exceededFallback 是上游未给出重置时间时超限状态保持的时长
+const exceededFallback = 5 * time.Minute
+
+// UsageLimit 是 session 最近一次收到的额度状态
+type UsageLimit struct {
+	Type      string     `json:"type"`
+	Remaining *int       `json:"remaining,omitempty"`
+	ResetsAt  *time.Time `json:"resets_at,omitempty"`
+	PerModel  bool       `json:"per_model_limit,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// expired 报告额度是否已经重置，重置后的状态不再影响 session 选择
+func (l UsageLimit) expired(now time.Time) bool {
+	if l.ResetsAt != nil {
+		return !now.Before(*l.ResetsAt)
+	}
+	return l.Type == UsageExceededLimit && now.Sub(l.UpdatedAt) > exceededFallback
+}
+
+// Rank 返回额度状态对应的选择优先级
+func (l UsageLimit) Rank() int {
+	switch {
+	case l.Type == UsageExceededLimit || (l.Remaining != nil && *l.Remaining <= 0):
+		return UsageRankExceeded
+	case l.Type == UsageApproachingLimit:
+		return UsageRankApproaching
+	default:
+		return UsageRankAvailable
+	}
+}
+
+// UsageTracker 在内存中按 session key 和组织记录额度状态
+type UsageTracker struct {
+	mutex  sync.Mutex
+	limits map[string]UsageLimit
+}
+
+// Usage 是进程内共享的额度状态
+var Usage = &UsageTracker{limits: map[string]UsageLimit{}}
+
+// Record 保存 session 的最新额度状态
+func (u *UsageTracker) Record(sessionKey, orgID string, limit UsageLimit) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	limit.UpdatedAt = time.Now()
+	u.limits[sessionKey+":"+orgID] = limit
+}
+
+// Get 返回 session 尚未重置的额度状态
+func (u *UsageTracker) Get(sessionKey, orgID string) (UsageLimit, bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	key := sessionKey + ":" + orgID
+	limit, ok := u.limits[key]
+	if ok && limit.expired(time.Now()) {
+		delete(u.limits, key)
+		return UsageLimit{}, false
+	}
+	return limit, ok
+}
+
+// Rank 返回 session 的选择优先级，没有额度信息时视为可用
+func (u *UsageTracker) Rank(session SessionInfo) int {
+	limit, ok := u.Get(session.SessionKey, session.OrgID)
+	if !ok {
+		return UsageRankAvailable
+	}
+	return limit.Rank()
+}
@@ -2,16 +2,15 @@ package core
 
 import (
 	"bufio"
+	"bytes"
 	"claude2api/config"
 	"claude2api/logger"
 	"claude2api/model"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
@@ -26,6 +25,23 @@ type Client struct {
 	client       *req.Client
 	model        string
 	defaultAttrs map[string]interface{}
+	// UUID of the last assistant message, used as parent of follow-up messages
+	lastMessageUUID string
+	limits          OutputLimits
+	artifactMode    string
+	tools           UpstreamTools
+	thinking        *bool
+	// claude.ai project the conversation is created in
+	projectUUID string
+	// artifacts of this client by the identifier given by the model
+	artifacts map[string]*model.Artifact
+	// owner of the artifacts stored for /v1/artifacts/{id}
+	artifactOwner string
+	onArtifact    func(model.Artifact)
+	onCitation    func(model.Annotation)
+	onSearch      func(query string, results []model.SearchResult)
+	// receives thinking instead of the text when set
+	onThinking func(string)
 }
 
 type ResponseEvent struct {
@@ -33,6 +49,10 @@ type ResponseEvent struct {
 	Index        int    `json:"index"`
 	ContentBlock struct {
 		Type string `json:"type"`
+		Name string `json:"name"`
+		// tool_use input and tool_result content, parsed by tool
+		Input   json.RawMessage `json:"input"`
+		Content json.RawMessage `json:"content"`
 	} `json:"content_block"`
 	Delta struct {
 		Type     string `json:"type"`
@@ -40,10 +60,21 @@ type ResponseEvent struct {
 		THINKING string `json:"thinking"`
 		// partial_json
 		PartialJSON string `json:"partial_json"`
+		// citation_start_delta / citation_end_delta
+		Citation struct {
+			UUID  string `json:"uuid"`
+			URL   string `json:"url"`
+			Title string `json:"title"`
+		} `json:"citation"`
+		CitationUUID string `json:"citation_uuid"`
 	} `json:"delta"`
 	Error struct {
 		Message string `json:"message"`
 	} `json:"error"`
+	Message struct {
+		UUID string `json:"uuid"`
+	} `json:"message"`
+	MessageLimit MessageLimit `json:"message_limit"`
 }
 
 func NewClient(sessionKey string, proxy string, model string) *Client {
@@ -55,7 +86,7 @@ func NewClient(sessionKey string, proxy string, model string) *Client {
 	// Set common headers
 	headers := map[string]string{
 		"accept":                    "text/event-stream, text/event-stream",
-		"accept-language":           "zh-CN,zh;q=0.9",
+		"accept-language":           config.ConfigInstance.Locale,
 		"anthropic-client-platform": "web_claude_ai",
 		"content-type":              "application/json",
 		"origin":                    config.ConfigInstance.BaseURL,
@@ -84,34 +115,16 @@ func NewClient(sessionKey string, proxy string, model string) *Client {
 		client:     client,
 		model:      model,
 		defaultAttrs: map[string]interface{}{
-			"personalized_styles": []map[string]interface{}{
-				{
-					"type":       "default",
-					"key":        "Default",
-					"name":       "Normal",
-					"nameKey":    "normal_style_name",
-					"prompt":     "Normal",
-					"summary":    "Default responses from Claude",
-					"summaryKey": "normal_style_summary",
-					"isDefault":  true,
-				},
-			},
-			"tools": []map[string]interface{}{
-				{
-					"type": "web_search_v0",
-					"name": "web_search",
-				},
-				{"type": "artifacts_v0", "name": "artifacts"},
-				{"type": "repl_v0", "name": "repl"},
-			},
+			"personalized_styles": []Style{normalStyle},
 			"parent_message_uuid": "00000000-0000-4000-8000-000000000000",
 			"attachments":         []interface{}{},
 			"files":               []interface{}{},
 			"sync_sources":        []interface{}{},
 			"rendering_mode":      "messages",
-			"timezone":            "America/Los_Angeles",
+			"timezone":            config.ConfigInstance.Timezone,
 		},
 	}
+	c.SetUpstreamTools(AllUpstreamTools())
 	return c
 }
 
@@ -120,56 +133,154 @@ func (c *Client) SetOrgID(orgID string) {
 	c.orgID = orgID
 }
 
+// SetOutputLimits sets the stop sequences and token limit enforced by HandleResponse
+func (c *Client) SetOutputLimits(limits OutputLimits) {
+	c.limits = limits
+}
+
+// Organization describes an organization visible to the session key
+type Organization struct {
+	ID            int      `json:"id"`
+	UUID          string   `json:"uuid"`
+	Name          string   `json:"name"`
+	RateLimitTier string   `json:"rate_limit_tier"`
+	Capabilities  []string `json:"capabilities"`
+}
+
+// ErrOrgInvalid is returned when the upstream rejects the organization ID used by the client
+var ErrOrgInvalid = errors.New("organization is invalid or not accessible")
+
+// ErrProjectInvalid is returned when the upstream rejects the project of the conversation, which
+// says nothing about the organization
+var ErrProjectInvalid = errors.New("project is invalid or not accessible")
 
-func (c *Client) GetOrgID() (string, error) {
+// OrgSelector picks an organization by name, tier or capability; empty fields are ignored
+type OrgSelector struct {
+	Name       string
+	Tier       string
+	Capability string
+}
+
+// defaultOrgTiers are the rate limit tiers preferred when no selector is configured
+var defaultOrgTiers = []string{"default_claude_ai", "default_claude_max_20x", "default_raven_enterprise"}
+
+// GetOrganizations lists every organization visible to the session key
+func (c *Client) GetOrganizations() ([]Organization, error) {
 	url := fmt.Sprintf("%s/api/organizations", config.ConfigInstance.BaseURL)
 	
 	// 打印详细的请求信息
-	logger.Info(fmt.Sprintf("🔗 [GetOrgID] 请求URL: %s", url))
-	logger.Info(fmt.Sprintf("🔗 [GetOrgID] 请求方法: GET"))
-	logger.Info(fmt.Sprintf("🔗 [GetOrgID] BaseURL: %s", config.ConfigInstance.BaseURL))
-	logger.Info(fmt.Sprintf("🔗 [GetOrgID] Referer: %s/new", config.ConfigInstance.BaseURL))
-	logger.Info(fmt.Sprintf("🔗 [GetOrgID] SessionKey: %s", c.SessionKey))
+	logger.Info(fmt.Sprintf("🔗 [GetOrganizations] 请求URL: %s", url))
+	logger.Info(fmt.Sprintf("🔗 [GetOrganizations] 请求方法: GET"))
+	logger.Info(fmt.Sprintf("🔗 [GetOrganizations] BaseURL: %s", config.ConfigInstance.BaseURL))
+	logger.Info(fmt.Sprintf("🔗 [GetOrganizations] Referer: %s/new", config.ConfigInstance.BaseURL))
+	logger.Info(fmt.Sprintf("🔗 [GetOrganizations] SessionKey: %s", c.SessionKey))
 	
 	resp, err := c.client.R().
 		SetHeader("referer", fmt.Sprintf("%s/new", config.ConfigInstance.BaseURL)).
 		Get(url)
 	if err != nil {
-		logger.Error(fmt.Sprintf("🔗 [GetOrgID] 请求失败: %v", err))
-		return "", fmt.Errorf("request failed: %w", err)
+		logger.Error(fmt.Sprintf("🔗 [GetOrganizations] 请求失败: %v", err))
+		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	
-	logger.Info(fmt.Sprintf("🔗 [GetOrgID] 响应状态码: %d", resp.StatusCode))
-	logger.Info(fmt.Sprintf("🔗 [GetOrgID] 响应内容: %s", resp.String()))
+	logger.Info(fmt.Sprintf("🔗 [GetOrganizations] 响应状态码: %d", resp.StatusCode))
+	logger.Info(fmt.Sprintf("🔗 [GetOrganizations] 响应内容: %s", resp.String()))
 	
 	if resp.StatusCode != http.StatusOK {
-		logger.Error(fmt.Sprintf("🔗 [GetOrgID] 意外的状态码: %d", resp.StatusCode))
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-	type OrgResponse []struct {
-		ID            int    `json:"id"`
-		UUID          string `json:"uuid"`
-		Name          string `json:"name"`
-		RateLimitTier string `json:"rate_limit_tier"`
+		logger.Error(fmt.Sprintf("🔗 [GetOrganizations] 意外的状态码: %d", resp.StatusCode))
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	var orgs OrgResponse
+	var orgs []Organization
 	if err := json.Unmarshal(resp.Bytes(), &orgs); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 	if len(orgs) == 0 {
-		return "", errors.New("no organizations found")
+		return nil, errors.New("no organizations found")
+	}
+	return orgs, nil
+}
+
+// GetOrgID resolves the organization the session should use according to the selector
+func (c *Client) GetOrgID(selector OrgSelector) (Organization, error) {
+	orgs, err := c.GetOrganizations()
+	if err != nil {
+		return Organization{}, err
+	}
+	return SelectOrganization(orgs, selector)
+}
+
+// SelectOrganization applies the selector to the organization list. Without a selector a
+// single organization is used as is, then the default tiers are preferred, then any
+// organization that can chat.
+func SelectOrganization(orgs []Organization, selector OrgSelector) (Organization, error) {
+	if selector.Name != "" || selector.Tier != "" || selector.Capability != "" {
+		for _, org := range orgs {
+			if selector.Name != "" && !strings.EqualFold(org.Name, selector.Name) {
+				continue
+			}
+			if selector.Tier != "" && org.RateLimitTier != selector.Tier {
+				continue
+			}
+			if selector.Capability != "" && !org.HasCapability(selector.Capability) {
+				continue
+			}
+			return org, nil
+		}
+		return Organization{}, fmt.Errorf("no organization matches name=%q tier=%q capability=%q", selector.Name, selector.Tier, selector.Capability)
 	}
 	if len(orgs) == 1 {
-		return orgs[0].UUID, nil
+		return orgs[0], nil
+	}
+	for _, tier := range defaultOrgTiers {
+		for _, org := range orgs {
+			if org.RateLimitTier == tier {
+				return org, nil
+			}
+		}
 	}
 	for _, org := range orgs {
-		if org.RateLimitTier == "default_claude_ai" || org.RateLimitTier == "default_claude_max_20x" || org.RateLimitTier == "default_raven_enterprise" {
-			return org.UUID, nil
+		if org.HasCapability("chat") {
+			logger.Info(fmt.Sprintf("No default tier organization found, using %s (%s)", org.Name, org.RateLimitTier))
+			return org, nil
 		}
 	}
-	return "", errors.New("no default organization found")
+	return Organization{}, errors.New("no default organization found")
+}
 
+// HasCapability reports whether the organization advertises the capability
+func (o Organization) HasCapability(capability string) bool {
+	for _, c := range o.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAccountEmail returns the email address of the account that owns the session key
+func (c *Client) GetAccountEmail() (string, error) {
+	url := fmt.Sprintf("%s/api/account", config.ConfigInstance.BaseURL)
+	logger.Info(fmt.Sprintf("🔗 [GetAccountEmail] 请求URL: %s", url))
+
+	resp, err := c.client.R().
+		SetHeader("referer", fmt.Sprintf("%s/new", config.ConfigInstance.BaseURL)).
+		Get(url)
+	if err != nil {
+		logger.Error(fmt.Sprintf("🔗 [GetAccountEmail] 请求失败: %v", err))
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	logger.Info(fmt.Sprintf("🔗 [GetAccountEmail] 响应状态码: %d", resp.StatusCode))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	var account struct {
+		EmailAddress string `json:"email_address"`
+	}
+	if err := json.Unmarshal(resp.Bytes(), &account); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return account.EmailAddress, nil
 }
 
 // CreateConversation creates a new conversation and returns its UUID
@@ -179,9 +290,13 @@ func (c *Client) CreateConversation() (string, error) {
 	}
 	url := fmt.Sprintf("%s/api/organizations/%s/chat_conversations", config.ConfigInstance.BaseURL, c.orgID)
 	
-	// 如果以-think结尾
-	if strings.HasSuffix(c.model, "-think") {
-		c.model = strings.TrimSuffix(c.model, "-think")
+	// 如果以-think结尾，SetThinking 的设置优先
+	thinking := strings.HasSuffix(c.model, "-think")
+	c.model = strings.TrimSuffix(c.model, "-think")
+	if c.thinking != nil {
+		thinking = *c.thinking
+	}
+	if thinking {
 		if err := c.UpdateUserSetting("paprika_mode", "extended"); err != nil {
 			logger.Error(fmt.Sprintf("Failed to update paprika_mode: %v", err))
 		}
@@ -200,6 +315,10 @@ func (c *Client) CreateConversation() (string, error) {
 		// 删除model
 		delete(requestBody, "model")
 	}
+	if c.projectUUID != "" {
+		// 在项目中创建对话，项目指令和知识库生效
+		requestBody["project_uuid"] = c.projectUUID
+	}
 
 	// 打印详细的请求信息
 	requestBodyJSON, _ := json.Marshal(requestBody)
@@ -224,6 +343,16 @@ func (c *Client) CreateConversation() (string, error) {
 	logger.Info(fmt.Sprintf("🔗 [CreateConversation] 响应状态码: %d", resp.StatusCode))
 	logger.Info(fmt.Sprintf("🔗 [CreateConversation] 响应内容: %s", resp.String()))
 	
+	if (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound) && c.projectUUID != "" {
+		// 项目已删除或无权访问，丢弃缓存的项目列表，组织仍然有效
+		logger.Error(fmt.Sprintf("🔗 [CreateConversation] 项目无效: %d", resp.StatusCode))
+		Projects.delete(c.SessionKey + ":" + c.orgID)
+		return "", fmt.Errorf("%w: %s: status code %d", ErrProjectInvalid, c.projectUUID, resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound {
+		logger.Error(fmt.Sprintf("🔗 [CreateConversation] 组织无效: %d", resp.StatusCode))
+		return "", fmt.Errorf("%w: status code %d", ErrOrgInvalid, resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusCreated {
 		logger.Error(fmt.Sprintf("🔗 [CreateConversation] 意外的状态码: %d", resp.StatusCode))
 		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
@@ -243,8 +372,93 @@ func (c *Client) CreateConversation() (string, error) {
 
 // SendMessage sends a message to a conversation and returns the status and response
 func (c *Client) SendMessage(conversationID string, message string, stream bool, gc *gin.Context) (int, error) {
+	body, status, err := c.postMessage(conversationID, message, stream)
+	if err != nil {
+		return status, err
+	}
+	return status, c.HandleResponse(body, stream, gc)
+}
+
+// SendMessageCollect sends a message and returns the full response text without writing to a client
+func (c *Client) SendMessageCollect(conversationID string, message string) (string, error) {
+	body, _, err := c.postMessage(conversationID, message, false)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	return c.readResponse(body, nil, func(string, bool) bool { return true })
+}
+
+// ChoiceOutput is the result of SendMessageChoice besides the emitted text
+type ChoiceOutput struct {
+	FinishReason string
+	// Artifacts are the final versions of the artifacts in structured mode
+	Artifacts []model.Artifact
+	// Annotations are the url_citation annotations inside the emitted text
+	Annotations []model.Annotation
+}
+
+// SendMessageChoice sends a message and passes the output, limited by the output limits, to emit.
+// It returns the finish reason, artifacts and annotations, or the UpstreamError when claude.ai
+// reports an error in the stream. Used when the output is not written as one chat completion.
+func (c *Client) SendMessageChoice(conversationID string, message string, done <-chan struct{}, emit func(string)) (ChoiceOutput, error) {
+	body, _, err := c.postMessage(conversationID, message, true)
+	if err != nil {
+		return ChoiceOutput{}, err
+	}
+	defer body.Close()
+	var result ChoiceOutput
+	c.onArtifact = func(artifact model.Artifact) {
+		result.Artifacts = mergeArtifact(result.Artifacts, artifact)
+	}
+	hook := c.onCitation
+	c.onCitation = func(annotation model.Annotation) {
+		result.Annotations = append(result.Annotations, annotation)
+		if hook != nil {
+			hook(annotation)
+		}
+	}
+	defer func() {
+		c.onArtifact = nil
+		c.onCitation = hook
+	}()
+	limiter := newOutputLimiter(c.limits)
+	var output strings.Builder
+	_, err = c.readResponse(body, done, func(text string, answer bool) bool {
+		out, stop := limiter.write(text, answer)
+		if out != "" {
+			output.WriteString(out)
+			emit(out)
+		}
+		return !stop
+	})
+	if err != nil {
+		return ChoiceOutput{}, err
+	}
+	if tail := limiter.flush(); tail != "" {
+		output.WriteString(tail)
+		emit(tail)
+	}
+	result.FinishReason = limiter.FinishReason()
+	result.Annotations = clampAnnotations(result.Annotations, output.String())
+	return result, nil
+}
+
+// mergeArtifact adds an artifact, replacing the previous version of an updated artifact
+func mergeArtifact(artifacts []model.Artifact, artifact model.Artifact) []model.Artifact {
+	for i := range artifacts {
+		if artifacts[i].ID == artifact.ID {
+			artifacts[i] = artifact
+			return artifacts
+		}
+	}
+	return append(artifacts, artifact)
+}
+
+// postMessage posts the completion request and returns the SSE body
+func (c *Client) postMessage(conversationID string, message string, stream bool) (io.ReadCloser, int, error) {
 	if c.orgID == "" {
-		return 500, errors.New("organization ID not set")
+		return nil, 500, errors.New("organization ID not set")
 	}
 	url := fmt.Sprintf("%s/api/organizations/%s/chat_conversations/%s/completion",
 		config.ConfigInstance.BaseURL, c.orgID, conversationID)
@@ -280,7 +494,7 @@ func (c *Client) SendMessage(conversationID string, message string, stream bool,
 		Post(url)
 	if err != nil {
 		logger.Error(fmt.Sprintf("🔗 [SendMessage] 请求失败: %v", err))
-		return 500, fmt.Errorf("request failed: %w", err)
+		return nil, 500, fmt.Errorf("request failed: %w", err)
 	}
 	
 	logger.Info(fmt.Sprintf("🔗 [SendMessage] 响应状态码: %d", resp.StatusCode))
@@ -288,18 +502,76 @@ func (c *Client) SendMessage(conversationID string, message string, stream bool,
 	
 	if resp.StatusCode == http.StatusTooManyRequests {
 		logger.Error(fmt.Sprintf("🔗 [SendMessage] 速率限制: %d", resp.StatusCode))
-		return http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded")
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.recordRateLimited(body)
+		return nil, http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded")
 	}
 	if resp.StatusCode != http.StatusOK {
 		logger.Error(fmt.Sprintf("🔗 [SendMessage] 意外的状态码: %d", resp.StatusCode))
-		return resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		resp.Body.Close()
+		return nil, resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
-	return 200, c.HandleResponse(resp.Body, stream, gc)
+	return resp.Body, 200, nil
 }
 
+// PrepareFollowUp makes the next message continue the conversation after the last answer
+// instead of branching from the root. Attachments and files were already sent and are cleared.
+func (c *Client) PrepareFollowUp(conversationID string) error {
+	if c.lastMessageUUID == "" {
+		leaf, err := c.getConversationLeaf(conversationID)
+		if err != nil {
+			return err
+		}
+		c.lastMessageUUID = leaf
+	}
+	c.defaultAttrs["parent_message_uuid"] = c.lastMessageUUID
+	c.defaultAttrs["attachments"] = []interface{}{}
+	c.defaultAttrs["files"] = []interface{}{}
+	return nil
+}
+
+// getConversationLeaf returns the UUID of the latest message of a conversation
+func (c *Client) getConversationLeaf(conversationID string) (string, error) {
+	url := fmt.Sprintf("%s/api/organizations/%s/chat_conversations/%s?tree=True&rendering_mode=messages",
+		config.ConfigInstance.BaseURL, c.orgID, conversationID)
+	resp, err := c.client.R().
+		SetHeader("referer", fmt.Sprintf("%s/chat/%s", config.ConfigInstance.BaseURL, conversationID)).
+		Get(url)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	var conversation struct {
+		CurrentLeafMessageUUID string `json:"current_leaf_message_uuid"`
+	}
+	if err := json.Unmarshal(resp.Bytes(), &conversation); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	if conversation.CurrentLeafMessageUUID == "" {
+		return "", errors.New("conversation has no messages")
+	}
+	return conversation.CurrentLeafMessageUUID, nil
+}
+
+// UpstreamError is an error event sent by claude.ai inside the response stream
+type UpstreamError struct {
+	Message string
+}
+
+func (e *UpstreamError) Error() string {
+	return "upstream error: " + e.Message
+}
+
+var errClientClosed = errors.New("client closed connection")
+
 // HandleResponse converts Claude's SSE format to OpenAI format and writes to the response writer
 func (c *Client) HandleResponse(body io.ReadCloser, stream bool, gc *gin.Context) error {
 	defer body.Close()
+	// 流式响应使用上一次记录的额度，非流式响应在结束时更新
+	c.SetRateLimitHeaders(gc)
 	// Set headers for streaming
 	if stream {
 		gc.Writer.Header().Set("Content-Type", "text/event-stream")
@@ -309,22 +581,125 @@ func (c *Client) HandleResponse(body io.ReadCloser, stream bool, gc *gin.Context
 		gc.Writer.WriteHeader(http.StatusOK)
 		gc.Writer.Flush()
 	}
+	limiter := newOutputLimiter(c.limits)
+	var output strings.Builder
+	var artifacts []model.Artifact
+	asToolCalls := c.artifactMode == ArtifactModeToolCalls
+	c.onArtifact = func(artifact model.Artifact) {
+		// tool_calls 增量的参数按下标拼接，更新不能再次发送，所以在流结束时统一发送
+		if stream && !asToolCalls {
+			model.ReturnOpenAIArtifactChunk(artifact, 0, false, gc)
+		}
+		// 同一 artifact 更新后替换之前的版本
+		artifacts = mergeArtifact(artifacts, artifact)
+	}
+	var annotations []model.Annotation
+	var search *model.WebSearch
+	c.onCitation = func(annotation model.Annotation) {
+		annotations = append(annotations, annotation)
+		if stream {
+			model.ReturnOpenAIAnnotationChunk([]model.Annotation{annotation}, nil, gc)
+		}
+	}
+	c.onSearch = func(query string, results []model.SearchResult) {
+		if query == "" && len(results) == 0 {
+			return
+		}
+		if search == nil {
+			search = &model.WebSearch{}
+		}
+		if query != "" {
+			search.Queries = append(search.Queries, query)
+		}
+		search.Results = append(search.Results, results...)
+		if stream {
+			update := &model.WebSearch{Results: results}
+			if query != "" {
+				update.Queries = []string{query}
+			}
+			model.ReturnOpenAIAnnotationChunk(nil, update, gc)
+		}
+	}
+	defer func() {
+		c.onArtifact = nil
+		c.onCitation = nil
+		c.onSearch = nil
+	}()
+	_, err := c.readResponse(body, gc.Request.Context().Done(), func(text string, answer bool) bool {
+		out, stop := limiter.write(text, answer)
+		output.WriteString(out)
+		if stream && out != "" {
+			model.ReturnOpenAIResponse(out, stream, gc)
+		}
+		if stop {
+			// 关闭响应体即取消上游的生成
+			logger.Info(fmt.Sprintf("Output limit reached, finish reason: %s", limiter.FinishReason()))
+		}
+		return !stop
+	})
+	var upstreamErr *UpstreamError
+	switch {
+	case errors.Is(err, errClientClosed):
+		logger.Info("Client closed connection")
+		return nil
+	case errors.As(err, &upstreamErr):
+		model.ReturnOpenAIResponse(upstreamErr.Message, stream, gc)
+		return nil
+	case err != nil:
+		return err
+	}
+	tail := limiter.flush()
+	output.WriteString(tail)
+	finishReason := limiter.FinishReason()
+	if asToolCalls && len(artifacts) > 0 {
+		finishReason = "tool_calls"
+	}
+	if !stream {
+		c.SetRateLimitHeaders(gc)
+		model.ReturnOpenAIMessage(output.String(), finishReason, model.MessageExtras{
+			Artifacts:   artifacts,
+			AsToolCalls: asToolCalls,
+			Annotations: clampAnnotations(annotations, output.String()),
+			WebSearch:   search,
+		}, gc)
+	} else {
+		if tail != "" {
+			model.ReturnOpenAIResponse(tail, stream, gc)
+		}
+		// 每个 artifact 以首次出现的下标发送一次，参数为最终版本
+		if asToolCalls {
+			for i, artifact := range artifacts {
+				model.ReturnOpenAIArtifactChunk(artifact, i, true, gc)
+			}
+		}
+		model.ReturnOpenAIFinish("", finishReason, stream, gc)
+		// 发送结束标志
+		gc.Writer.Write([]byte("data: [DONE]\n\n"))
+		gc.Writer.Flush()
+	}
+
+	return nil
+}
+
+// readResponse parses Claude's SSE stream, calls emit for every text chunk and returns the full text.
+// answer is false for reasoning and artifact blocks, which output limits do not apply to.
+// Reading stops when emit returns false. A nil done channel never cancels.
+func (c *Client) readResponse(body io.Reader, done <-chan struct{}, emit func(text string, answer bool) bool) (string, error) {
 	scanner := bufio.NewScanner(body)
-	clientDone := gc.Request.Context().Done()
 	// Keep track of the full response for the final message
 	thinkingShown := false
 	res_all_text := ""
 	partial_json_shown := false
-	useTool := false
-	useToolEnd := false
-	nextLanguage := false
-	languageStr := "md"
+	var toolInput *toolInputStream
+	toolName := ""
+	var toolRaw strings.Builder
+	var citations citationTracker
+	var toolStartInput json.RawMessage
 	for scanner.Scan() {
 		select {
-		case <-clientDone:
+		case <-done:
 			// 客户端已断开连接，清理资源并退出
-			logger.Info("Client closed connection")
-			return nil
+			return res_all_text, errClientClosed
 		default:
 			// 继续处理响应
 		}
@@ -337,14 +712,28 @@ func (c *Client) HandleResponse(body io.ReadCloser, stream bool, gc *gin.Context
 		var event ResponseEvent
 		if err := json.Unmarshal([]byte(data), &event); err == nil {
 			if event.Type == "error" && event.Error.Message != "" {
-				model.ReturnOpenAIResponse(event.Error.Message, stream, gc)
-				return nil
+				return res_all_text, &UpstreamError{Message: event.Error.Message}
+			}
+			if event.Type == "message_start" && event.Message.UUID != "" {
+				c.lastMessageUUID = event.Message.UUID
 			}
-			if event.ContentBlock.Type == "tool_use" {
-				useTool = true
+			if event.Type == "message_limit" {
+				c.recordMessageLimit(event.MessageLimit)
 			}
-			if event.ContentBlock.Type == "tool_result" {
-				useToolEnd = true
+			if event.Type == "content_block_start" {
+				toolInput = nil
+				toolName = ""
+				if event.ContentBlock.Type == "tool_use" {
+					toolName = event.ContentBlock.Name
+				}
+				toolRaw.Reset()
+				if event.ContentBlock.Type == "tool_result" && event.ContentBlock.Name == webSearchToolName && c.onSearch != nil {
+					c.onSearch("", parseSearchResults(event.ContentBlock.Content))
+				}
+				toolStartInput = event.ContentBlock.Input
+				if event.ContentBlock.Type == "tool_use" {
+					toolInput = newToolInputStream()
+				}
 			}
 			if event.Type == "content_block_stop" {
 				res_text := ""
@@ -352,24 +741,52 @@ func (c *Client) HandleResponse(body io.ReadCloser, stream bool, gc *gin.Context
 					res_text = "</think>\n"
 					thinkingShown = false
 				}
+				fenced := partial_json_shown
 				if partial_json_shown {
 					res_text = "\n```\n"
 					partial_json_shown = false
 				}
+				// 没有 input_json_delta 时输入已在 content_block_start 中给出
+				toolInputJSON := toolRaw.String()
+				if toolInputJSON == "" {
+					toolInputJSON = string(toolStartInput)
+				}
+				if toolName == artifactToolName {
+					res_text += c.finishArtifact(toolInputJSON, fenced)
+				}
+				if toolName == webSearchToolName && c.onSearch != nil {
+					if query := parseSearchQuery(toolInputJSON); query != "" {
+						c.onSearch(query, nil)
+					}
+				}
+				toolInput = nil
+				toolName = ""
 				res_all_text += res_text
-				if !stream {
-					continue
+				if !emit(res_text, false) {
+					return res_all_text, nil
+				}
+				continue
+			}
+			if event.Delta.Type == "citation_start_delta" {
+				citations.start(event.Delta.Citation.UUID, event.Delta.Citation.URL, event.Delta.Citation.Title, res_all_text)
+				continue
+			}
+			if event.Delta.Type == "citation_end_delta" {
+				if annotation, ok := citations.end(event.Delta.CitationUUID, res_all_text); ok && c.onCitation != nil {
+					c.onCitation(annotation)
 				}
-				model.ReturnOpenAIResponse(res_text, stream, gc)
 				continue
 			}
 			if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
 				res_text := event.Delta.Text
 				res_all_text += res_text
-				if !stream {
-					continue
+				if !emit(res_text, true) {
+					return res_all_text, nil
 				}
-				model.ReturnOpenAIResponse(res_text, stream, gc)
+				continue
+			}
+			if event.Delta.Type == "thinking_delta" && c.onThinking != nil {
+				c.onThinking(event.Delta.THINKING)
 				continue
 			}
 			if event.Delta.Type == "thinking_delta" {
@@ -379,114 +796,40 @@ func (c *Client) HandleResponse(body io.ReadCloser, stream bool, gc *gin.Context
 					thinkingShown = true
 				}
 				res_all_text += res_text
-				if !stream {
-					continue
+				if !emit(res_text, false) {
+					return res_all_text, nil
 				}
-				model.ReturnOpenAIResponse(res_text, stream, gc)
 				continue
 			}
 			if event.Delta.Type == "input_json_delta" {
-				res_text := event.Delta.PartialJSON
-				//结束使用工具了
-				if useTool && res_text == ",\"content\":" {
-					useTool = false
-					partial_json_shown = false
-					continue
-				}
-				//获取语言,下一次就是了
-				if res_text == ",\"language\":" || res_text == ",\"type\":" {
-					nextLanguage = true
+				// 工具输入的 content 字段即 artifact 正文，解码后放入代码块
+				toolRaw.WriteString(event.Delta.PartialJSON)
+				if toolName == artifactToolName && c.structuredArtifacts() {
 					continue
 				}
-				//获取语言注入
-				if nextLanguage {
-					languageStr = res_text[1:]
-					logger.Info(fmt.Sprintf("获取的语言为:%s", languageStr))
-					if languageStr == "text/html" {
-						languageStr = "html"
-					}
-					nextLanguage = false
-				}
-				//使用工具
-				if useTool {
-					logger.Info(fmt.Sprintf("useTool res_text:%s", res_text))
-					continue
+				if toolInput == nil {
+					toolInput = newToolInputStream()
 				}
-				//使用了工具结束拉
-				if useToolEnd {
-					useToolEnd = false
+				res_text := toolInput.Write(event.Delta.PartialJSON)
+				if res_text == "" {
 					continue
 				}
-				//存在代码首字母为"的情况,特殊处理
-				if strings.HasPrefix(res_text, "\"") {
-					res_text = res_text[1:]
-				}
-				//可能会存在多出一个}的情况
-				if res_text == "\"}" || res_text == "}" {
-					res_text = ""
-				}
-				//转义
-				unquote, err := strconv.Unquote(fmt.Sprintf("\"%s\"", res_text))
-				if err == nil {
-					res_text = unquote
-				} else {
-					logger.Error(fmt.Sprintf("转化出错:%s", err.Error()))
-					res_text = strings.ReplaceAll(res_text, "\\\\n", "")
-					res_text = strings.ReplaceAll(res_text, "\\\\u", "\\u")
-					res_text = strings.ReplaceAll(res_text, "\\\"", "\"")
-					res_text = strings.ReplaceAll(res_text, "\\\\'", "'")
-					res_text = strings.ReplaceAll(res_text, "\\n", "\n")
-					res_text = strings.ReplaceAll(res_text, "\\t", "\t")
-					res_text = decodeUnicodeEscape(res_text)
-				}
-
 				if !partial_json_shown {
-					res_text = "\n```" + languageStr + "\n" + res_text
+					res_text = "\n```" + artifactLanguage(toolInput) + "\n" + res_text
 					partial_json_shown = true
 				}
 				res_all_text += res_text
-				if !stream {
-					continue
+				if !emit(res_text, false) {
+					return res_all_text, nil
 				}
-				model.ReturnOpenAIResponse(res_text, stream, gc)
 				continue
 			}
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading response: %w", err)
+		return res_all_text, fmt.Errorf("error reading response: %w", err)
 	}
-	if !stream {
-		model.ReturnOpenAIResponse(res_all_text, stream, gc)
-	} else {
-		// 发送结束标志
-		gc.Writer.Write([]byte("data: [DONE]\n\n"))
-		gc.Writer.Flush()
-	}
-
-	return nil
-}
-func decodeUnicodeEscape(s string) string {
-	var result []rune
-	for i := 0; i < len(s); i++ {
-		// 检查是否是 Unicode 转义序列
-		if len(s)-i >= 6 && s[i:i+2] == "\\u" {
-			// 尝试解析 Unicode 码点
-			code, err := strconv.ParseInt(s[i+2:i+6], 16, 32)
-			if err == nil {
-				// 将码点转换为字符
-				result = append(result, rune(code))
-				// 跳过已处理的 Unicode 转义序列
-				i += 5
-			} else {
-				// 如果解析失败，保留原始字符
-				result = append(result, rune(s[i]))
-			}
-		} else {
-			result = append(result, rune(s[i]))
-		}
-	}
-	return string(result)
+	return res_all_text, nil
 }
 
 // DeleteConversation deletes a conversation by ID
@@ -530,13 +873,12 @@ func (c *Client) DeleteConversation(conversationID string) error {
 	return nil
 }
 
-// UploadFile uploads files to Claude and adds them to the client's default attributes
-// fileData should be in the format: data:image/jpeg;base64,/9j/4AA...
-func (c *Client) UploadFile(fileData []string) error {
+// UploadFile uploads images and PDFs to Claude and adds them to the client's default attributes
+func (c *Client) UploadFile(files []model.FileInput) error {
 	if c.orgID == "" {
 		return errors.New("organization ID not set")
 	}
-	if len(fileData) == 0 {
+	if len(files) == 0 {
 		return errors.New("empty file data")
 	}
 
@@ -546,47 +888,24 @@ func (c *Client) UploadFile(fileData []string) error {
 	}
 
 	// Process each file
-	for _, fd := range fileData {
-		if fd == "" {
-			continue // Skip empty entries
-		}
+	for _, file := range files {
+		fileBytes := file.Data
+		contentType := file.ContentType
+		filename := file.FileName
 
-		// Parse the base64 data
-		parts := strings.SplitN(fd, ",", 2)
-		if len(parts) != 2 {
-			return errors.New("invalid file data format")
-		}
-
-		// Get the content type from the data URI
-		metaParts := strings.SplitN(parts[0], ":", 2)
-		if len(metaParts) != 2 {
-			return errors.New("invalid content type in file data")
-		}
-
-		metaInfo := strings.SplitN(metaParts[1], ";", 2)
-		if len(metaInfo) != 2 || metaInfo[1] != "base64" {
-			return errors.New("invalid encoding in file data")
-		}
-
-		contentType := metaInfo[0]
-
-		// Decode the base64 data
-		fileBytes, err := base64.StdEncoding.DecodeString(parts[1])
-		if err != nil {
-			return fmt.Errorf("failed to decode base64 data: %w", err)
-		}
-
-		// Determine filename based on content type
-		var filename string
-		switch contentType {
-		case "image/jpeg":
-			filename = "image.jpg"
-		case "image/png":
-			filename = "image.png"
-		case "application/pdf":
-			filename = "document.pdf"
-		default:
-			filename = "file"
+		// Reuse a previous upload of the same content in this organization. The file is checked on
+		// every reuse, since deleting a conversation may delete its files.
+		hash := HashContent(fileBytes)
+		if UploadCache.Enabled() {
+			if entry, ok := UploadCache.Lookup(c.orgID, hash); ok {
+				if c.fileExists(entry) {
+					logger.Info(fmt.Sprintf("🔗 [UploadFile] 复用已上传文件: %s", entry.FileUUID))
+					c.defaultAttrs["files"] = append(c.defaultAttrs["files"].([]interface{}), entry.FileUUID)
+					continue
+				}
+				logger.Info(fmt.Sprintf("🔗 [UploadFile] 缓存文件已失效: %s", entry.FileUUID))
+				UploadCache.Invalidate(c.orgID, hash)
+			}
 		}
 
 		// Create the upload URL
@@ -607,7 +926,15 @@ func (c *Client) UploadFile(fileData []string) error {
 		resp, err := c.client.R().
 			SetHeader("referer", fmt.Sprintf("%s/new", config.ConfigInstance.BaseURL)).
 			SetHeader("anthropic-client-platform", "web_claude_ai").
-			SetFileBytes("file", filename, fileBytes).
+			SetFileUpload(req.FileUpload{
+				ParamName: "file",
+				FileName:  filename,
+				GetFileContent: func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(fileBytes)), nil
+				},
+				FileSize:    int64(len(fileBytes)),
+				ContentType: contentType,
+			}).
 			SetContentType("multipart/form-data").
 			Post(url)
 
@@ -626,7 +953,8 @@ func (c *Client) UploadFile(fileData []string) error {
 
 		// Parse the response
 		var result struct {
-			FileUUID string `json:"file_uuid"`
+			FileUUID   string `json:"file_uuid"`
+			PreviewURL string `json:"preview_url"`
 		}
 
 		if err := json.Unmarshal(resp.Bytes(), &result); err != nil {
@@ -637,6 +965,14 @@ func (c *Client) UploadFile(fileData []string) error {
 			return errors.New("file UUID not found in response")
 		}
 
+		if UploadCache.Enabled() {
+			UploadCache.Store(c.orgID, hash, fileCacheEntry{
+				FileUUID:   result.FileUUID,
+				PreviewURL: result.PreviewURL,
+				Uploaded:   time.Now(),
+			})
+		}
+
 		// Add file to default attributes
 		c.defaultAttrs["files"] = append(c.defaultAttrs["files"].([]interface{}), result.FileUUID)
 	}
@@ -644,16 +980,34 @@ func (c *Client) UploadFile(fileData []string) error {
 	return nil
 }
 
-func (c *Client) SetBigContext(context string) {
-	c.defaultAttrs["attachments"] = []map[string]interface{}{
-		{
-			"file_name":         "context.txt",
-			"file_type":         "text/plain",
-			"file_size":         len(context),
-			"extracted_content": context,
-		},
+// fileExists checks that a previously uploaded file is still available upstream
+func (c *Client) fileExists(entry fileCacheEntry) bool {
+	previewURL := entry.PreviewURL
+	if previewURL == "" {
+		previewURL = fmt.Sprintf("/api/%s/files/%s/preview", c.orgID, entry.FileUUID)
+	}
+	url := config.ConfigInstance.BaseURL + previewURL
+	logger.Info(fmt.Sprintf("🔗 [FileExists] 请求URL: %s", url))
+	resp, err := c.client.R().
+		SetHeader("referer", fmt.Sprintf("%s/new", config.ConfigInstance.BaseURL)).
+		Get(url)
+	if err != nil {
+		logger.Error(fmt.Sprintf("🔗 [FileExists] 请求失败: %v", err))
+		return false
 	}
+	logger.Info(fmt.Sprintf("🔗 [FileExists] 响应状态码: %d", resp.StatusCode))
+	return resp.StatusCode == http.StatusOK
+}
 
+// AddAttachment adds a text document to the message attachments as extracted content
+func (c *Client) AddAttachment(fileName string, fileType string, content string) {
+	attachments, _ := c.defaultAttrs["attachments"].([]interface{})
+	c.defaultAttrs["attachments"] = append(attachments, map[string]interface{}{
+		"file_name":         fileName,
+		"file_type":         fileType,
+		"file_size":         len(content),
+		"extracted_content": content,
+	})
 }
 
 // / UpdateUserSetting updates a single user setting on Claude.ai while preserving all other settings
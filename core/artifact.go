@@ -0,0 +1,186 @@
+package core
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"claude2api/model"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Artifact modes, markdown keeps artifacts inline as fenced code
+const (
+	ArtifactModeMarkdown   = "markdown"
+	ArtifactModeStructured = "structured"
+	ArtifactModeToolCalls  = "tool_calls"
+	ArtifactModeResource   = "resource"
+)
+
+// artifactToolName is the name of the upstream tool creating artifacts
+const artifactToolName = "artifacts"
+
+// maxStoredArtifacts bounds the in-memory artifact store
+const maxStoredArtifacts = 1000
+
+// artifactInput is the input of the upstream artifacts tool
+type artifactInput struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Command  string `json:"command"`
+	Content  string `json:"content"`
+	Language string `json:"language"`
+	OldStr   string `json:"old_str"`
+	NewStr   string `json:"new_str"`
+}
+
+type storedArtifact struct {
+	Artifact model.Artifact
+	Owner    string
+	Stored   time.Time
+}
+
+// ArtifactStore keeps artifacts served by /v1/artifacts/{id} for ArtifactTTL minutes
+type ArtifactStore struct {
+	mutex   sync.Mutex
+	entries map[string]storedArtifact
+	order   []string
+}
+
+// Artifacts is the process wide artifact store
+var Artifacts = &ArtifactStore{entries: map[string]storedArtifact{}}
+
+// Put stores an artifact of the owner, replacing the previous version with the same ID
+func (s *ArtifactStore) Put(artifact model.Artifact, owner string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.entries[artifact.ID]; !ok {
+		s.order = append(s.order, artifact.ID)
+	}
+	s.entries[artifact.ID] = storedArtifact{Artifact: artifact, Owner: owner, Stored: time.Now()}
+	for len(s.order) > maxStoredArtifacts {
+		delete(s.entries, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Get returns a stored artifact of the owner that has not expired
+func (s *ArtifactStore) Get(id, owner string) (model.Artifact, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[id]
+	if !ok || entry.Owner != owner || time.Since(entry.Stored) > time.Duration(config.ConfigInstance.ArtifactTTL)*time.Minute {
+		return model.Artifact{}, false
+	}
+	return entry.Artifact, true
+}
+
+// SetArtifactMode sets how artifacts of the upstream artifacts tool are returned
+func (c *Client) SetArtifactMode(mode string) {
+	c.artifactMode = mode
+}
+
+// SetArtifactOwner sets the owner of the artifacts stored by this client
+func (c *Client) SetArtifactOwner(owner string) {
+	c.artifactOwner = owner
+}
+
+// structuredArtifacts reports whether artifacts are taken out of the text
+func (c *Client) structuredArtifacts() bool {
+	return c.artifactMode != "" && c.artifactMode != ArtifactModeMarkdown
+}
+
+// applyArtifact parses the complete tool input and applies it to the artifacts of this client.
+// Updates replace old_str with new_str in the current content, other commands set the content.
+func (c *Client) applyArtifact(raw string) (model.Artifact, bool) {
+	var input artifactInput
+	if err := json.Unmarshal([]byte(raw), &input); err != nil {
+		logger.Error(fmt.Sprintf("Failed to parse artifact input: %v", err))
+		return model.Artifact{}, false
+	}
+	if c.artifacts == nil {
+		c.artifacts = map[string]*model.Artifact{}
+	}
+	artifact, ok := c.artifacts[input.ID]
+	if !ok {
+		artifact = &model.Artifact{ID: "artifact-" + uuid.New().String(), Identifier: input.ID}
+		c.artifacts[input.ID] = artifact
+	}
+	if input.Title != "" {
+		artifact.Title = input.Title
+	}
+	if input.Type != "" {
+		artifact.Type = input.Type
+	}
+	if input.Language != "" {
+		artifact.Language = input.Language
+	}
+	artifact.Language = languageFor(artifact.Type, artifact.Language)
+	if input.Command == "update" {
+		if !strings.Contains(artifact.Content, input.OldStr) {
+			logger.Error(fmt.Sprintf("Artifact %s update did not match its content", input.ID))
+		}
+		artifact.Content = strings.Replace(artifact.Content, input.OldStr, input.NewStr, 1)
+	} else {
+		artifact.Content = input.Content
+	}
+	if c.structuredArtifacts() {
+		Artifacts.Put(*artifact, c.artifactOwner)
+	}
+	return *artifact, true
+}
+
+// finishArtifact applies a completed artifacts tool call and returns the text to emit. In
+// markdown mode an update, which streams no content, is emitted as a fence with the full result.
+// In resource mode the link is emitted once, when the artifact is created.
+func (c *Client) finishArtifact(raw string, fenced bool) string {
+	known := len(c.artifacts)
+	artifact, ok := c.applyArtifact(raw)
+	if !ok {
+		return ""
+	}
+	switch c.artifactMode {
+	case ArtifactModeStructured, ArtifactModeToolCalls:
+		if c.onArtifact != nil {
+			c.onArtifact(artifact)
+		}
+		return ""
+	case ArtifactModeResource:
+		if len(c.artifacts) == known {
+			return ""
+		}
+		title := artifact.Title
+		if title == "" {
+			title = artifact.Identifier
+		}
+		return fmt.Sprintf("\n[%s](/v1/artifacts/%s)\n", title, artifact.ID)
+	default:
+		if fenced || artifact.Content == "" {
+			return ""
+		}
+		return "\n```" + artifact.Language + "\n" + artifact.Content + "\n```\n"
+	}
+}
+
+// languageFor returns the code fence language of an artifact type
+func languageFor(artifactType, language string) string {
+	if language != "" {
+		return language
+	}
+	switch artifactType {
+	case "text/html":
+		return "html"
+	case "image/svg+xml":
+		return "svg"
+	case "application/vnd.ant.mermaid":
+		return "mermaid"
+	case "application/vnd.ant.react":
+		return "jsx"
+	}
+	return "md"
+}
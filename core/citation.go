@@ -0,0 +1,92 @@
+package core
+
+import (
+	"claude2api/model"
+	"encoding/json"
+	"unicode/utf8"
+)
+
+// webSearchToolName is the name of the upstream web search tool
+const webSearchToolName = "web_search"
+
+// citationTracker turns citation_start_delta/citation_end_delta events into url_citation
+// annotations. Indices count characters of the response text.
+type citationTracker struct {
+	open map[string]model.Annotation
+}
+
+func (t *citationTracker) start(uuid, url, title string, text string) {
+	if t.open == nil {
+		t.open = map[string]model.Annotation{}
+	}
+	t.open[uuid] = model.Annotation{
+		Type: "url_citation",
+		URLCitation: model.URLCitation{
+			URL:        url,
+			Title:      title,
+			StartIndex: utf8.RuneCountInString(text),
+		},
+	}
+}
+
+func (t *citationTracker) end(uuid string, text string) (model.Annotation, bool) {
+	annotation, ok := t.open[uuid]
+	if !ok {
+		return annotation, false
+	}
+	delete(t.open, uuid)
+	annotation.URLCitation.EndIndex = utf8.RuneCountInString(text)
+	return annotation, true
+}
+
+// OnCitation passes every url_citation annotation to fn as soon as the cited text is complete.
+// Used with SendMessageChoice, which also returns the annotations at the end.
+func (c *Client) OnCitation(fn func(model.Annotation)) {
+	c.onCitation = fn
+}
+
+// parseSearchResults reads the results of a web_search tool_result block
+func parseSearchResults(raw json.RawMessage) []model.SearchResult {
+	var items []struct {
+		Type  string `json:"type"`
+		Title string `json:"title"`
+		URL   string `json:"url"`
+	}
+	if len(raw) == 0 || json.Unmarshal(raw, &items) != nil {
+		return nil
+	}
+	var results []model.SearchResult
+	for _, item := range items {
+		if item.URL != "" {
+			results = append(results, model.SearchResult{URL: item.URL, Title: item.Title})
+		}
+	}
+	return results
+}
+
+// parseSearchQuery reads the query of a web_search tool input
+func parseSearchQuery(raw string) string {
+	var input struct {
+		Query string `json:"query"`
+	}
+	if json.Unmarshal([]byte(raw), &input) != nil {
+		return ""
+	}
+	return input.Query
+}
+
+// clampAnnotations keeps the annotations inside text, which may have been cut by output limits
+func clampAnnotations(annotations []model.Annotation, text string) []model.Annotation {
+	length := utf8.RuneCountInString(text)
+	kept := make([]model.Annotation, 0, len(annotations))
+	for _, annotation := range annotations {
+		if annotation.URLCitation.StartIndex >= length {
+			continue
+		}
+		if annotation.URLCitation.EndIndex > length {
+			annotation.URLCitation.EndIndex = length
+		}
+		kept = append(kept, annotation)
+	}
+	return kept
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type fileCacheEntry struct {
+	FileUUID   string
+	PreviewURL string
+	Uploaded   time.Time
+}
+
+// FileCacheStats reports how often repeated attachments were served from the cache
+type FileCacheStats struct {
+	Entries int     `json:"entries"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	Stale   int64   `json:"stale"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// FileCache maps the content hash of an uploaded file to its file_uuid per organization
+type FileCache struct {
+	mutex   sync.Mutex
+	entries map[string]fileCacheEntry
+	hits    int64
+	misses  int64
+	stale   int64
+}
+
+// UploadCache is the process wide cache used by UploadFile
+var UploadCache = &FileCache{entries: map[string]fileCacheEntry{}}
+
+// HashContent returns the cache key for file content
+func HashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func fileCacheTTL() time.Duration {
+	return time.Duration(config.ConfigInstance.FileCacheTTL) * time.Minute
+}
+
+// Enabled reports whether deduplication is turned on
+func (fc *FileCache) Enabled() bool {
+	return config.ConfigInstance.FileCacheTTL > 0
+}
+
+// Lookup returns the cached entry for the content hash in the organization
+func (fc *FileCache) Lookup(orgID, hash string) (fileCacheEntry, bool) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	key := orgID + ":" + hash
+	entry, ok := fc.entries[key]
+	if ok && time.Since(entry.Uploaded) > fileCacheTTL() {
+		delete(fc.entries, key)
+		ok = false
+	}
+	result := "miss"
+	if ok {
+		fc.hits++
+		result = "hit"
+	} else {
+		fc.misses++
+	}
+	logger.Info(fmt.Sprintf("File cache %s for %s (hits=%d misses=%d hit rate=%.1f%%)",
+		result, hash[:12], fc.hits, fc.misses, fc.hitRate()))
+	return entry, ok
+}
+
+// Store records an uploaded file
+func (fc *FileCache) Store(orgID, hash string, entry fileCacheEntry) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.entries[orgID+":"+hash] = entry
+}
+
+// Invalidate drops a cached file that no longer exists upstream; it is counted as stale instead of a hit
+func (fc *FileCache) Invalidate(orgID, hash string) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	delete(fc.entries, orgID+":"+hash)
+	fc.hits--
+	fc.misses++
+	fc.stale++
+}
+
+// Stats returns a snapshot of the cache counters
+func (fc *FileCache) Stats() FileCacheStats {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return FileCacheStats{
+		Entries: len(fc.entries),
+		Hits:    fc.hits,
+		Misses:  fc.misses,
+		Stale:   fc.stale,
+		HitRate: fc.hitRate(),
+	}
+}
+
+func (fc *FileCache) hitRate() float64 {
+	total := fc.hits + fc.misses
+	if total == 0 {
+		return 0
+	}
+	return float64(fc.hits) * 100 / float64(total)
+}
@@ -0,0 +1,277 @@
+package core
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// toolInputStream incrementally tokenizes the JSON object of a tool input streamed in
+// input_json_delta events. The top-level "content" string is decoded as it arrives; other
+// top-level string fields are kept so the artifact language can be derived from them.
+// Fragments may split the input anywhere, including inside escape sequences.
+type toolInputStream struct {
+	state   int
+	depth   int  // nesting depth of skipped non-string values
+	escaped bool // previous byte was a backslash
+	hex     []byte
+	high    rune // pending high surrogate of a \u escape pair
+	key     strings.Builder
+	field   string
+	value   strings.Builder
+	pending []byte // incomplete UTF-8 sequence of the streamed field
+	fields  map[string]string
+}
+
+const (
+	jsonStateStart    = iota // before the opening brace
+	jsonStateKey             // expecting a key, a comma or the closing brace
+	jsonStateKeyText         // inside a key
+	jsonStateColon           // expecting the colon after a key
+	jsonStateValue           // expecting a value
+	jsonStateString          // inside a top-level string value
+	jsonStateSkip            // inside a nested or scalar value
+	jsonStateSkipText        // inside a string of a skipped value
+	jsonStateDone            // after the closing brace
+)
+
+// streamedField is the tool input field emitted as the artifact body
+const streamedField = "content"
+
+// maxCapturedField limits the kept size of other top-level string fields
+const maxCapturedField = 256
+
+func newToolInputStream() *toolInputStream {
+	return &toolInputStream{fields: map[string]string{}}
+}
+
+// Write consumes a fragment and returns the newly decoded text of the streamed field
+func (s *toolInputStream) Write(fragment string) string {
+	var out strings.Builder
+	for i := 0; i < len(fragment); i++ {
+		b := fragment[i]
+		switch s.state {
+		case jsonStateStart:
+			if b == '{' {
+				s.state = jsonStateKey
+			}
+		case jsonStateKey:
+			if b == '"' {
+				s.key.Reset()
+				s.state = jsonStateKeyText
+			} else if b == '}' {
+				s.state = jsonStateDone
+			}
+		case jsonStateKeyText:
+			if r, ok := s.decode(b); ok {
+				s.key.WriteString(r)
+			} else if s.closed(b) {
+				s.state = jsonStateColon
+			}
+		case jsonStateColon:
+			if b == ':' {
+				s.field = s.key.String()
+				s.state = jsonStateValue
+			}
+		case jsonStateValue:
+			switch {
+			case b == '"':
+				s.value.Reset()
+				s.pending = s.pending[:0]
+				s.state = jsonStateString
+			case b == '{' || b == '[':
+				s.depth = 1
+				s.state = jsonStateSkip
+			case b == ',':
+				s.state = jsonStateKey
+			case b == '}':
+				s.state = jsonStateDone
+			case !isJSONSpace(b):
+				s.depth = 0
+				s.state = jsonStateSkip
+			}
+		case jsonStateString:
+			if r, ok := s.decode(b); ok {
+				s.appendValue(&out, r)
+			} else if s.closed(b) {
+				s.endString(&out)
+				s.state = jsonStateValue
+			}
+		case jsonStateSkip:
+			switch b {
+			case '"':
+				s.state = jsonStateSkipText
+			case '{', '[':
+				s.depth++
+			case '}', ']':
+				if s.depth == 0 {
+					// closing brace of the tool input after a scalar
+					s.state = jsonStateDone
+				} else if s.depth--; s.depth == 0 {
+					s.state = jsonStateValue
+				}
+			case ',':
+				if s.depth == 0 {
+					s.state = jsonStateKey
+				}
+			}
+		case jsonStateSkipText:
+			if s.escaped {
+				s.escaped = false
+			} else if b == '\\' {
+				s.escaped = true
+			} else if b == '"' {
+				s.state = jsonStateSkip
+			}
+		}
+	}
+	return out.String()
+}
+
+// Field returns a top-level string field, truncated to maxCapturedField bytes
+func (s *toolInputStream) Field(name string) string {
+	return s.fields[name]
+}
+
+// decode processes one byte inside a string. It returns decoded text when the byte completes
+// a character and false for the closing quote or a byte that is part of an escape sequence.
+func (s *toolInputStream) decode(b byte) (string, bool) {
+	if s.hex != nil {
+		s.hex = append(s.hex, b)
+		if len(s.hex) < 4 {
+			return "", false
+		}
+		r := rune(hexValue(s.hex))
+		s.hex = nil
+		return s.surrogate(r), true
+	}
+	if s.escaped {
+		s.escaped = false
+		if b == 'u' {
+			s.hex = make([]byte, 0, 4)
+			return "", false
+		}
+		prefix := s.flushSurrogate()
+		switch b {
+		case 'n':
+			return prefix + "\n", true
+		case 't':
+			return prefix + "\t", true
+		case 'r':
+			return prefix + "\r", true
+		case 'b':
+			return prefix + "\b", true
+		case 'f':
+			return prefix + "\f", true
+		default:
+			// \" \\ \/ and unknown escapes keep the character
+			return prefix + string(b), true
+		}
+	}
+	if b == '\\' {
+		s.escaped = true
+		return "", false
+	}
+	if b == '"' {
+		return "", false
+	}
+	return s.flushSurrogate() + string([]byte{b}), true
+}
+
+// closed reports whether b was the closing quote of the current string
+func (s *toolInputStream) closed(b byte) bool {
+	return b == '"' && !s.escaped && s.hex == nil
+}
+
+// surrogate combines \u escapes encoding a UTF-16 surrogate pair
+func (s *toolInputStream) surrogate(r rune) string {
+	if utf16.IsSurrogate(r) && r < 0xdc00 {
+		prefix := s.flushSurrogate()
+		s.high = r
+		return prefix
+	}
+	if s.high != 0 {
+		high := s.high
+		s.high = 0
+		if combined := utf16.DecodeRune(high, r); combined != utf8.RuneError {
+			return string(combined)
+		}
+		return string(utf8.RuneError) + string(r)
+	}
+	return string(r)
+}
+
+// flushSurrogate returns a replacement character for an unpaired high surrogate
+func (s *toolInputStream) flushSurrogate() string {
+	if s.high == 0 {
+		return ""
+	}
+	s.high = 0
+	return string(utf8.RuneError)
+}
+
+// appendValue adds decoded text to the current value, holding back an incomplete UTF-8 sequence
+func (s *toolInputStream) appendValue(out *strings.Builder, text string) {
+	if s.field != streamedField {
+		if s.value.Len() < maxCapturedField {
+			s.value.WriteString(text)
+		}
+		return
+	}
+	s.pending = append(s.pending, text...)
+	n := len(s.pending)
+	for cut := n - 1; cut >= 0 && cut >= n-utf8.UTFMax; cut-- {
+		if utf8.RuneStart(s.pending[cut]) {
+			if !utf8.FullRune(s.pending[cut:]) {
+				n = cut
+			}
+			break
+		}
+	}
+	out.Write(s.pending[:n])
+	s.pending = append(s.pending[:0], s.pending[n:]...)
+}
+
+// endString finishes the current top-level string value
+func (s *toolInputStream) endString(out *strings.Builder) {
+	tail := s.flushSurrogate()
+	if s.field == streamedField {
+		out.Write(s.pending)
+		out.WriteString(tail)
+		s.pending = s.pending[:0]
+		return
+	}
+	s.value.WriteString(tail)
+	value := s.value.String()
+	if len(value) > maxCapturedField {
+		value = value[:maxCapturedField]
+	}
+	s.fields[s.field] = value
+}
+
+func hexValue(digits []byte) int {
+	v := 0
+	for _, d := range digits {
+		v <<= 4
+		switch {
+		case d >= '0' && d <= '9':
+			v |= int(d - '0')
+		case d >= 'a' && d <= 'f':
+			v |= int(d-'a') + 10
+		case d >= 'A' && d <= 'F':
+			v |= int(d-'A') + 10
+		default:
+			return int(utf8.RuneError)
+		}
+	}
+	return v
+}
+
+func isJSONSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// artifactLanguage returns the code fence language of a tool input
+func artifactLanguage(input *toolInputStream) string {
+	return languageFor(input.Field("type"), input.Field("language"))
+}
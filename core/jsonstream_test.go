@@ -0,0 +1,166 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// fixtureStreams returns the SSE fixtures in testdata/jsonstream. They are hand-written in the
+// claude.ai event format, with input_json_delta fragments cut inside escapes, \u sequences and
+// surrogate pairs; they are not captures of real upstream streams.
+func fixtureStreams(t *testing.T) []string {
+	t.Helper()
+	streams, err := filepath.Glob(filepath.Join("testdata", "jsonstream", "*.sse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) == 0 {
+		t.Fatal("no SSE fixtures in testdata/jsonstream")
+	}
+	return streams
+}
+
+// toolInputFragments returns the input_json_delta fragments of every tool_use block in a stream
+func toolInputFragments(t *testing.T, stream []byte) [][]string {
+	t.Helper()
+	var blocks [][]string
+	scanner := bufio.NewScanner(bytes.NewReader(stream))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event ResponseEvent
+		if err := json.Unmarshal([]byte(line[6:]), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		switch {
+		case event.Type == "content_block_start" && event.ContentBlock.Type == "tool_use":
+			blocks = append(blocks, []string{})
+		case event.Delta.Type == "input_json_delta":
+			blocks[len(blocks)-1] = append(blocks[len(blocks)-1], event.Delta.PartialJSON)
+		}
+	}
+	return blocks
+}
+
+// tokenize feeds fragments to a toolInputStream and returns the decoded content
+func tokenize(fragments []string) (string, *toolInputStream) {
+	input := newToolInputStream()
+	var content strings.Builder
+	for _, fragment := range fragments {
+		content.WriteString(input.Write(fragment))
+	}
+	return content.String(), input
+}
+
+// checkGolden compares got with a golden file, rewriting it with -update
+func checkGolden(t *testing.T, path string, got string) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("output differs from %s\n--- got ---\n%s\n--- want ---\n%s", path, got, want)
+	}
+}
+
+func TestToolInputStreamGolden(t *testing.T) {
+	for _, path := range fixtureStreams(t) {
+		name := strings.TrimSuffix(filepath.Base(path), ".sse")
+		t.Run(name, func(t *testing.T) {
+			stream, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out strings.Builder
+			for i, fragments := range toolInputFragments(t, stream) {
+				content, input := tokenize(fragments)
+
+				// the streamed content must match a complete decode of the input
+				var whole artifactInput
+				if err := json.Unmarshal([]byte(strings.Join(fragments, "")), &whole); err != nil {
+					t.Fatalf("block %d: tool input is not valid JSON: %v", i, err)
+				}
+				if content != whole.Content {
+					t.Errorf("block %d: streamed content %q, want %q", i, content, whole.Content)
+				}
+				if input.Field("type") != whole.Type || input.Field("language") != whole.Language {
+					t.Errorf("block %d: fields type=%q language=%q, want type=%q language=%q",
+						i, input.Field("type"), input.Field("language"), whole.Type, whole.Language)
+				}
+				fmt.Fprintf(&out, "--- block %d type=%q language=%q\n%s\n", i, input.Field("type"), input.Field("language"), content)
+			}
+			checkGolden(t, strings.TrimSuffix(path, ".sse")+".tokens.golden", out.String())
+		})
+	}
+}
+
+// TestToolInputStreamSplits re-chunks every fixture input at each byte offset and one byte at a
+// time, so escapes, \u sequences, surrogate pairs and UTF-8 sequences are split everywhere.
+func TestToolInputStreamSplits(t *testing.T) {
+	for _, path := range fixtureStreams(t) {
+		stream, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, fragments := range toolInputFragments(t, stream) {
+			raw := strings.Join(fragments, "")
+			want, _ := tokenize([]string{raw})
+			for cut := 1; cut < len(raw); cut++ {
+				if got, _ := tokenize([]string{raw[:cut], raw[cut:]}); got != want {
+					t.Errorf("%s block %d split at %d: got %q, want %q", filepath.Base(path), i, cut, got, want)
+				}
+			}
+			bytewise := make([]string, len(raw))
+			for j := range raw {
+				bytewise[j] = raw[j : j+1]
+			}
+			if got, _ := tokenize(bytewise); got != want {
+				t.Errorf("%s block %d byte by byte: got %q, want %q", filepath.Base(path), i, got, want)
+			}
+		}
+	}
+}
+
+func TestReadResponseGolden(t *testing.T) {
+	for _, path := range fixtureStreams(t) {
+		name := strings.TrimSuffix(filepath.Base(path), ".sse")
+		t.Run(name, func(t *testing.T) {
+			stream, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &Client{}
+			client.SetArtifactMode(ArtifactModeMarkdown)
+			var emitted strings.Builder
+			text, err := client.readResponse(bytes.NewReader(stream), nil, func(chunk string, answer bool) bool {
+				emitted.WriteString(chunk)
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if emitted.String() != text {
+				t.Errorf("emitted chunks %q differ from the returned text %q", emitted.String(), text)
+			}
+			checkGolden(t, strings.TrimSuffix(path, ".sse")+".golden", text)
+		})
+	}
+}
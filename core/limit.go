@@ -0,0 +1,141 @@
+package core
+
+import (
+	"strings"
+	"unicode"
+)
+
+// OutputLimits are the client side stop sequences and token limit applied to generated text
+type OutputLimits struct {
+	Stop      []string
+	MaxTokens int
+}
+
+// outputLimiter enforces OutputLimits on streamed text. Text that may be the beginning of a
+// stop sequence is held back until the next chunk decides it.
+type outputLimiter struct {
+	stop         []string
+	maxQuarters  int // token limit in quarter tokens, 0 means unlimited
+	quarters     int
+	pending      string
+	finishReason string
+}
+
+func newOutputLimiter(limits OutputLimits) *outputLimiter {
+	l := &outputLimiter{maxQuarters: limits.MaxTokens * 4}
+	for _, s := range limits.Stop {
+		if s != "" {
+			l.stop = append(l.stop, s)
+		}
+	}
+	return l
+}
+
+// push returns the text that can be emitted and whether generation should stop
+func (l *outputLimiter) push(text string) (string, bool) {
+	if l.finishReason != "" {
+		return "", true
+	}
+	buf := l.pending + text
+	l.pending = ""
+	if idx := l.stopIndex(buf); idx >= 0 {
+		out, cut := l.count(buf[:idx])
+		if cut {
+			l.finishReason = "length"
+		} else {
+			l.finishReason = "stop"
+		}
+		return out, true
+	}
+	hold := l.partialStop(buf)
+	l.pending = buf[len(buf)-hold:]
+	out, cut := l.count(buf[:len(buf)-hold])
+	if cut {
+		l.pending = ""
+		l.finishReason = "length"
+		return out, true
+	}
+	return out, false
+}
+
+// write limits answer text and passes other text through unchanged. Held back answer text is
+// released first, so a stop sequence never spans a reasoning or artifact block.
+func (l *outputLimiter) write(text string, answer bool) (string, bool) {
+	if answer {
+		return l.push(text)
+	}
+	if l.finishReason != "" {
+		return "", true
+	}
+	out, cut := l.count(l.pending)
+	l.pending = ""
+	if cut {
+		l.finishReason = "length"
+		return out, true
+	}
+	return out + text, false
+}
+
+// flush returns the held back text once the stream has ended
+func (l *outputLimiter) flush() string {
+	if l.finishReason != "" {
+		return ""
+	}
+	out, cut := l.count(l.pending)
+	l.pending = ""
+	if cut {
+		l.finishReason = "length"
+	}
+	return out
+}
+
+// FinishReason returns stop or length
+func (l *outputLimiter) FinishReason() string {
+	if l.finishReason == "" {
+		return "stop"
+	}
+	return l.finishReason
+}
+
+// stopIndex returns the position of the earliest stop sequence in s, or -1
+func (l *outputLimiter) stopIndex(s string) int {
+	first := -1
+	for _, stop := range l.stop {
+		if idx := strings.Index(s, stop); idx >= 0 && (first < 0 || idx < first) {
+			first = idx
+		}
+	}
+	return first
+}
+
+// partialStop returns the length of the longest suffix of s that is a proper prefix of a stop sequence
+func (l *outputLimiter) partialStop(s string) int {
+	longest := 0
+	for _, stop := range l.stop {
+		for n := min(len(stop)-1, len(s)); n > longest; n-- {
+			if strings.HasSuffix(s, stop[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+// count adds the estimated tokens of s and cuts it at the token limit, reporting whether it was cut
+func (l *outputLimiter) count(s string) (string, bool) {
+	if l.maxQuarters <= 0 {
+		return s, false
+	}
+	for i, r := range s {
+		cost := 1
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			cost = 4
+		}
+		if l.quarters+cost > l.maxQuarters {
+			return s[:i], true
+		}
+		l.quarters += cost
+	}
+	return s, false
+}
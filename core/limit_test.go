@@ -0,0 +1,159 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+type limitChunk struct {
+	text   string
+	answer bool
+}
+
+func answer(text string) limitChunk   { return limitChunk{text, true} }
+func thinking(text string) limitChunk { return limitChunk{text, false} }
+
+// runLimiter writes chunks until the limiter stops and returns the emitted text, the number of
+// chunks consumed and the finish reason
+func runLimiter(limits OutputLimits, chunks []limitChunk) (string, int, string) {
+	l := newOutputLimiter(limits)
+	var out strings.Builder
+	for i, chunk := range chunks {
+		text, stop := l.write(chunk.text, chunk.answer)
+		out.WriteString(text)
+		if stop {
+			return out.String(), i + 1, l.FinishReason()
+		}
+	}
+	out.WriteString(l.flush())
+	return out.String(), len(chunks), l.FinishReason()
+}
+
+func TestOutputLimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   OutputLimits
+		chunks   []limitChunk
+		want     string
+		consumed int
+		reason   string
+	}{
+		{
+			name:   "no limits",
+			chunks: []limitChunk{answer("Hello "), answer("world")},
+			want:   "Hello world", consumed: 2, reason: "stop",
+		},
+		{
+			name:   "stop within a chunk",
+			limits: OutputLimits{Stop: []string{"END"}},
+			chunks: []limitChunk{answer("one END two"), answer("three")},
+			want:   "one ", consumed: 1, reason: "stop",
+		},
+		{
+			name:   "stop split across chunks",
+			limits: OutputLimits{Stop: []string{"STOP"}},
+			chunks: []limitChunk{answer("abc S"), answer("TO"), answer("P def")},
+			want:   "abc ", consumed: 3, reason: "stop",
+		},
+		{
+			name:   "partial prefix released by the next chunk",
+			limits: OutputLimits{Stop: []string{"STOP"}},
+			chunks: []limitChunk{answer("abc ST"), answer("ART")},
+			want:   "abc START", consumed: 2, reason: "stop",
+		},
+		{
+			name:   "partial prefix released at the end of the stream",
+			limits: OutputLimits{Stop: []string{"STOP"}},
+			chunks: []limitChunk{answer("abc"), answer(" STO")},
+			want:   "abc STO", consumed: 2, reason: "stop",
+		},
+		{
+			name:   "earliest of several stop strings",
+			limits: OutputLimits{Stop: []string{"\n\n", "Human:", "##"}},
+			chunks: []limitChunk{answer("text ## more\n\nHuman: x")},
+			want:   "text ", consumed: 1, reason: "stop",
+		},
+		{
+			name:   "longest partial prefix held back",
+			limits: OutputLimits{Stop: []string{"ab", "abcd"}},
+			chunks: []limitChunk{answer("xa"), answer("bc")},
+			want:   "x", consumed: 2, reason: "stop",
+		},
+		{
+			name:   "cut at exactly max_tokens",
+			limits: OutputLimits{MaxTokens: 2},
+			chunks: []limitChunk{answer("abcd"), answer("efgh"), answer("i")},
+			want:   "abcdefgh", consumed: 3, reason: "length",
+		},
+		{
+			name:   "text filling max_tokens is not cut",
+			limits: OutputLimits{MaxTokens: 2},
+			chunks: []limitChunk{answer("abcd"), answer("efgh")},
+			want:   "abcdefgh", consumed: 2, reason: "stop",
+		},
+		{
+			name:   "max_tokens inside a chunk",
+			limits: OutputLimits{MaxTokens: 1},
+			chunks: []limitChunk{answer("abcdefgh")},
+			want:   "abcd", consumed: 1, reason: "length",
+		},
+		{
+			name:   "CJK characters count one token each",
+			limits: OutputLimits{MaxTokens: 2},
+			chunks: []limitChunk{answer("你好世界")},
+			want:   "你好", consumed: 1, reason: "length",
+		},
+		{
+			name:   "max_tokens reached before a stop sequence",
+			limits: OutputLimits{Stop: []string{"END"}, MaxTokens: 1},
+			chunks: []limitChunk{answer("abcdefEND")},
+			want:   "abcd", consumed: 1, reason: "length",
+		},
+		{
+			name:   "thinking is neither counted nor matched",
+			limits: OutputLimits{Stop: []string{"END"}, MaxTokens: 1},
+			chunks: []limitChunk{thinking("<think> the END of a long thought"), thinking("</think>\n"), answer("abcd"), answer("e")},
+			want:   "<think> the END of a long thought</think>\nabcd", consumed: 4, reason: "length",
+		},
+		{
+			name:   "held back prefix released before thinking",
+			limits: OutputLimits{Stop: []string{"STOP"}},
+			chunks: []limitChunk{answer("a ST"), thinking("<think> OP"), thinking("</think>\n"), answer("b")},
+			want:   "a ST<think> OP</think>\nb", consumed: 4, reason: "stop",
+		},
+		{
+			name:   "artifact fence between answer deltas",
+			limits: OutputLimits{Stop: []string{"```"}},
+			chunks: []limitChunk{answer("Code:"), thinking("\n```go\nx := 1\n```\n"), answer("Done ``` later")},
+			want:   "Code:\n```go\nx := 1\n```\nDone ", consumed: 3, reason: "stop",
+		},
+		{
+			name:   "nothing passes after the limiter stopped",
+			limits: OutputLimits{Stop: []string{"END"}},
+			chunks: []limitChunk{answer("END"), thinking("<think> late")},
+			want:   "", consumed: 1, reason: "stop",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, consumed, reason := runLimiter(tt.limits, tt.chunks)
+			if got != tt.want || consumed != tt.consumed || reason != tt.reason {
+				t.Fatalf("got %q after %d chunks (%s), want %q after %d chunks (%s)",
+					got, consumed, reason, tt.want, tt.consumed, tt.reason)
+			}
+		})
+	}
+}
+
+func TestOutputLimiterFlushAfterStop(t *testing.T) {
+	l := newOutputLimiter(OutputLimits{Stop: []string{"STOP"}})
+	if out, stop := l.push("x STOP"); out != "x " || !stop {
+		t.Fatalf("push returned %q, %v", out, stop)
+	}
+	if out, stop := l.push("more"); out != "" || !stop {
+		t.Fatalf("push after stop returned %q, %v", out, stop)
+	}
+	if tail := l.flush(); tail != "" {
+		t.Fatalf("flush after stop returned %q", tail)
+	}
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// projectCacheTTL is how long the project list of an organization is reused
+const projectCacheTTL = 5 * time.Minute
+
+// Project is a claude.ai project
+type Project struct {
+	UUID        string `json:"uuid"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	IsPrivate   bool   `json:"is_private"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// ProjectDoc is a knowledge document of a project
+type ProjectDoc struct {
+	UUID      string `json:"uuid"`
+	FileName  string `json:"file_name"`
+	Content   string `json:"content,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+type projectCacheEntry struct {
+	projects []Project
+	fetched  time.Time
+}
+
+// ProjectCache keeps the project list per session key and organization, so routing a project
+// request does not list the projects of every session each time
+type ProjectCache struct {
+	mutex   sync.Mutex
+	entries map[string]projectCacheEntry
+}
+
+// Projects is the process wide cache used by FindProject
+var Projects = &ProjectCache{entries: map[string]projectCacheEntry{}}
+
+func (pc *ProjectCache) get(key string) ([]Project, bool) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	entry, ok := pc.entries[key]
+	if !ok || time.Since(entry.fetched) > projectCacheTTL {
+		return nil, false
+	}
+	return entry.projects, true
+}
+
+func (pc *ProjectCache) put(key string, projects []Project) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	pc.entries[key] = projectCacheEntry{projects: projects, fetched: time.Now()}
+}
+
+func (pc *ProjectCache) delete(key string) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	delete(pc.entries, key)
+}
+
+// ListProjects returns the projects of the organization visible to the session
+func (c *Client) ListProjects() ([]Project, error) {
+	if c.orgID == "" {
+		return nil, errors.New("organization ID not set")
+	}
+	url := fmt.Sprintf("%s/api/organizations/%s/projects", config.ConfigInstance.BaseURL, c.orgID)
+	logger.Info(fmt.Sprintf("🔗 [ListProjects] 请求URL: %s", url))
+	resp, err := c.client.R().
+		SetHeader("referer", fmt.Sprintf("%s/projects", config.ConfigInstance.BaseURL)).
+		Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	var projects []Project
+	if err := json.Unmarshal(resp.Bytes(), &projects); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	Projects.put(c.SessionKey+":"+c.orgID, projects)
+	return projects, nil
+}
+
+// FindProject looks up a project by uuid or case-insensitive name, using the cached project list
+func (c *Client) FindProject(name string) (Project, error) {
+	projects, ok := Projects.get(c.SessionKey + ":" + c.orgID)
+	if !ok {
+		var err error
+		if projects, err = c.ListProjects(); err != nil {
+			return Project{}, err
+		}
+	}
+	for _, project := range projects {
+		if project.UUID == name || strings.EqualFold(project.Name, name) {
+			return project, nil
+		}
+	}
+	return Project{}, fmt.Errorf("unknown project %q", name)
+}
+
+// UseProject creates the conversation inside the project, so its instructions and knowledge apply
+func (c *Client) UseProject(name string) error {
+	if name == "" {
+		return nil
+	}
+	project, err := c.FindProject(name)
+	if err != nil {
+		return err
+	}
+	logger.Info(fmt.Sprintf("Using project: %s (%s)", project.Name, project.UUID))
+	c.projectUUID = project.UUID
+	return nil
+}
+
+// ListProjectDocs returns the knowledge documents of a project
+func (c *Client) ListProjectDocs(projectUUID string) ([]ProjectDoc, error) {
+	url := fmt.Sprintf("%s/api/organizations/%s/projects/%s/docs", config.ConfigInstance.BaseURL, c.orgID, projectUUID)
+	logger.Info(fmt.Sprintf("🔗 [ListProjectDocs] 请求URL: %s", url))
+	resp, err := c.client.R().
+		SetHeader("referer", fmt.Sprintf("%s/project/%s", config.ConfigInstance.BaseURL, projectUUID)).
+		Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	var docs []ProjectDoc
+	if err := json.Unmarshal(resp.Bytes(), &docs); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return docs, nil
+}
+
+// UploadProjectDoc adds a text document to the knowledge of a project
+func (c *Client) UploadProjectDoc(projectUUID, fileName, content string) (ProjectDoc, error) {
+	url := fmt.Sprintf("%s/api/organizations/%s/projects/%s/docs", config.ConfigInstance.BaseURL, c.orgID, projectUUID)
+	logger.Info(fmt.Sprintf("🔗 [UploadProjectDoc] 请求URL: %s, 文件: %s (%d bytes)", url, fileName, len(content)))
+	resp, err := c.client.R().
+		SetHeader("referer", fmt.Sprintf("%s/project/%s", config.ConfigInstance.BaseURL, projectUUID)).
+		SetBody(map[string]string{"file_name": fileName, "content": content}).
+		Post(url)
+	if err != nil {
+		return ProjectDoc{}, fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return ProjectDoc{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	var doc ProjectDoc
+	if err := json.Unmarshal(resp.Bytes(), &doc); err != nil {
+		return ProjectDoc{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return doc, nil
+}
+
+// DeleteProjectDoc removes a knowledge document from a project
+func (c *Client) DeleteProjectDoc(projectUUID, docUUID string) error {
+	url := fmt.Sprintf("%s/api/organizations/%s/projects/%s/docs/%s", config.ConfigInstance.BaseURL, c.orgID, projectUUID, docUUID)
+	logger.Info(fmt.Sprintf("🔗 [DeleteProjectDoc] 请求URL: %s", url))
+	resp, err := c.client.R().
+		SetHeader("referer", fmt.Sprintf("%s/project/%s", config.ConfigInstance.BaseURL, projectUUID)).
+		Delete(url)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
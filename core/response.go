@@ -0,0 +1,101 @@
+package core
+
+import (
+	"claude2api/config"
+	"claude2api/model"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	// maxStoredResponses and maxStoredResponseBytes bound the memory used by stored responses
+	maxStoredResponses     = 1000
+	maxStoredResponseBytes = 64 * 1024 * 1024
+)
+
+// StoredResponse is a response of /v1/responses together with the conversation it ends,
+// which a later request continues through previous_response_id
+type StoredResponse struct {
+	Response model.Response
+	// Messages are the chat messages of the conversation including the answer, without instructions
+	Messages []map[string]interface{}
+	// Owner identifies the API key that created the response
+	Owner  string
+	Stored time.Time
+	size   int
+}
+
+// ResponseStore keeps responses in memory for ResponseTTL minutes
+type ResponseStore struct {
+	mutex   sync.Mutex
+	entries map[string]StoredResponse
+	order   []string
+	bytes   int
+}
+
+// Responses is the process wide response store
+var Responses = &ResponseStore{entries: map[string]StoredResponse{}}
+
+// Put stores a response, dropping the oldest ones above maxStoredResponses or maxStoredResponseBytes.
+// It reports false when the response alone is larger than the byte limit.
+func (s *ResponseStore) Put(stored StoredResponse) bool {
+	stored.size = responseSize(stored)
+	if stored.size > maxStoredResponseBytes {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := stored.Response.ID
+	if previous, ok := s.entries[id]; ok {
+		s.bytes -= previous.size
+	} else {
+		s.order = append(s.order, id)
+	}
+	stored.Stored = time.Now()
+	s.entries[id] = stored
+	s.bytes += stored.size
+	for len(s.order) > maxStoredResponses || s.bytes > maxStoredResponseBytes {
+		s.bytes -= s.entries[s.order[0]].size
+		delete(s.entries, s.order[0])
+		s.order = s.order[1:]
+	}
+	return true
+}
+
+// responseSize estimates the memory of a stored response by its JSON encoding
+func responseSize(stored StoredResponse) int {
+	response, _ := json.Marshal(stored.Response)
+	messages, _ := json.Marshal(stored.Messages)
+	return len(response) + len(messages)
+}
+
+// Get returns a stored response of the owner that has not expired
+func (s *ResponseStore) Get(id, owner string) (StoredResponse, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[id]
+	if !ok || entry.Owner != owner || time.Since(entry.Stored) > time.Duration(config.ConfigInstance.ResponseTTL)*time.Minute {
+		return StoredResponse{}, false
+	}
+	return entry, true
+}
+
+// Delete removes a stored response of the owner
+func (s *ResponseStore) Delete(id, owner string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[id]
+	if !ok || entry.Owner != owner {
+		return false
+	}
+	delete(s.entries, id)
+	s.bytes -= entry.size
+	for i, stored := range s.order {
+		if stored == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// styleCacheTTL is how long the style list of an organization is reused
+const styleCacheTTL = 5 * time.Minute
+
+// ErrUnknownStyle is returned when a style name matches no proxy or account style
+var ErrUnknownStyle = errors.New("unknown style")
+
+// Style is a personalized style as sent in the completion request
+type Style map[string]interface{}
+
+type styleCacheEntry struct {
+	styles  []Style
+	fetched time.Time
+}
+
+// StyleCache keeps the style list per session key and organization, so account styles are not
+// listed again for every conversation
+type StyleCache struct {
+	mutex   sync.Mutex
+	entries map[string]styleCacheEntry
+}
+
+// Styles is the process wide cache used by FindStyle
+var Styles = &StyleCache{entries: map[string]styleCacheEntry{}}
+
+func (sc *StyleCache) get(key string) ([]Style, bool) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	entry, ok := sc.entries[key]
+	if !ok || time.Since(entry.fetched) > styleCacheTTL {
+		return nil, false
+	}
+	return entry.styles, true
+}
+
+func (sc *StyleCache) put(key string, styles []Style) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.entries[key] = styleCacheEntry{styles: styles, fetched: time.Now()}
+}
+
+// normalStyle is the default style of claude.ai, used when the account styles cannot be listed
+var normalStyle = Style{
+	"type":       "default",
+	"key":        "Default",
+	"name":       "Normal",
+	"nameKey":    "normal_style_name",
+	"prompt":     "Normal",
+	"summary":    "Default responses from Claude",
+	"summaryKey": "normal_style_summary",
+	"isDefault":  true,
+}
+
+// IsKnownStyle reports whether a style name is normal or defined in the proxy config, which
+// can be checked without a session. Other names are looked up in the account styles.
+func IsKnownStyle(name string) bool {
+	if _, ok := config.ConfigInstance.Styles[name]; ok {
+		return true
+	}
+	return strings.EqualFold(name, "normal")
+}
+
+// proxyStyle converts a style from the proxy config to a custom personalized style
+func proxyStyle(key string, style config.StyleConfig) Style {
+	name := style.Name
+	if name == "" {
+		name = key
+	}
+	return Style{
+		"type":      "custom",
+		"key":       "proxy-" + key,
+		"name":      name,
+		"prompt":    style.Prompt,
+		"summary":   style.Summary,
+		"isDefault": false,
+	}
+}
+
+// ListStyles returns the default and custom styles of the organization
+func (c *Client) ListStyles() ([]Style, error) {
+	if c.orgID == "" {
+		return nil, errors.New("organization ID not set")
+	}
+	url := fmt.Sprintf("%s/api/organizations/%s/list_styles", config.ConfigInstance.BaseURL, c.orgID)
+	logger.Info(fmt.Sprintf("🔗 [ListStyles] 请求URL: %s", url))
+	resp, err := c.client.R().
+		SetHeader("referer", fmt.Sprintf("%s/new", config.ConfigInstance.BaseURL)).
+		Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	var result struct {
+		DefaultStyles []Style `json:"defaultStyles"`
+		CustomStyles  []Style `json:"customStyles"`
+	}
+	if err := json.Unmarshal(resp.Bytes(), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	styles := append(result.DefaultStyles, result.CustomStyles...)
+	Styles.put(c.SessionKey+":"+c.orgID, styles)
+	return styles, nil
+}
+
+// FindStyle looks up a style in the proxy config and then in the default and custom styles of
+// the account by key, uuid or name, using the cached style list. normal falls back to the
+// built-in default style when the account styles cannot be listed.
+func (c *Client) FindStyle(name string) (Style, error) {
+	if style, ok := config.ConfigInstance.Styles[name]; ok {
+		return proxyStyle(name, style), nil
+	}
+	styles, ok := Styles.get(c.SessionKey + ":" + c.orgID)
+	if !ok {
+		var err error
+		if styles, err = c.ListStyles(); err != nil {
+			if strings.EqualFold(name, "normal") {
+				logger.Error(fmt.Sprintf("Failed to list styles, using the built-in normal style: %v", err))
+				return normalStyle, nil
+			}
+			return nil, err
+		}
+	}
+	for _, style := range styles {
+		for _, field := range []string{"key", "uuid", "name"} {
+			if value, ok := style[field].(string); ok && strings.EqualFold(value, name) {
+				return style, nil
+			}
+		}
+	}
+	if strings.EqualFold(name, "normal") {
+		return normalStyle, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownStyle, name)
+}
+
+// UseStyle selects the personalized style of the conversation
+func (c *Client) UseStyle(name string) error {
+	if name == "" {
+		return nil
+	}
+	style, err := c.FindStyle(name)
+	if err != nil {
+		return err
+	}
+	c.setStyle(style)
+	return nil
+}
+
+func (c *Client) setStyle(style Style) {
+	logger.Info(fmt.Sprintf("Using style: %v", style["name"]))
+	c.defaultAttrs["personalized_styles"] = []Style{style}
+}
@@ -0,0 +1,52 @@
+package core
+
+// UpstreamTools selects the claude.ai tools enabled for a conversation
+type UpstreamTools struct {
+	WebSearch bool
+	Artifacts bool
+	REPL      bool
+}
+
+// AllUpstreamTools enables every tool, the default of a new client
+func AllUpstreamTools() UpstreamTools {
+	return UpstreamTools{WebSearch: true, Artifacts: true, REPL: true}
+}
+
+// SetUpstreamTools sets the tools sent with each message. The account setting for web search
+// stays enabled: it is shared by concurrent conversations, so only the tools list toggles it.
+func (c *Client) SetUpstreamTools(tools UpstreamTools) {
+	c.tools = tools
+	list := []map[string]interface{}{}
+	if tools.WebSearch {
+		list = append(list, map[string]interface{}{"type": "web_search_v0", "name": "web_search"})
+	}
+	if tools.Artifacts {
+		list = append(list, map[string]interface{}{"type": "artifacts_v0", "name": "artifacts"})
+	}
+	if tools.REPL {
+		list = append(list, map[string]interface{}{"type": "repl_v0", "name": "repl"})
+	}
+	c.defaultAttrs["tools"] = list
+}
+
+// SetThinking turns extended thinking on or off, overriding the -think model suffix. claude.ai
+// has no thinking budget, only this account setting updated when the conversation is created.
+func (c *Client) SetThinking(enabled bool) {
+	c.thinking = &enabled
+}
+
+// SetLocale overrides the timezone sent with each message and the Accept-Language header,
+// empty values keep the configured defaults
+func (c *Client) SetLocale(timezone, acceptLanguage string) {
+	if timezone != "" {
+		c.defaultAttrs["timezone"] = timezone
+	}
+	if acceptLanguage != "" {
+		c.client.SetCommonHeader("accept-language", acceptLanguage)
+	}
+}
+
+// OnThinking passes the thinking output to fn instead of wrapping it in <think> tags in the text
+func (c *Client) OnThinking(fn func(string)) {
+	c.onThinking = fn
+}
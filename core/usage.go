@@ -0,0 +1,78 @@
+package core
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MessageLimit is the quota information of a message_limit event
+type MessageLimit struct {
+	Type          string `json:"type"`
+	ResetsAt      *int64 `json:"resetsAt"`
+	Remaining     *int   `json:"remaining"`
+	PerModelLimit *bool  `json:"perModelLimit"`
+}
+
+// recordMessageLimit stores the quota of the session reported by the upstream
+func (c *Client) recordMessageLimit(limit MessageLimit) {
+	if limit.Type == "" {
+		return
+	}
+	usage := config.UsageLimit{Type: limit.Type, Remaining: limit.Remaining}
+	if limit.ResetsAt != nil && *limit.ResetsAt > 0 {
+		resetsAt := time.Unix(*limit.ResetsAt, 0)
+		usage.ResetsAt = &resetsAt
+	}
+	if limit.PerModelLimit != nil {
+		usage.PerModel = *limit.PerModelLimit
+	}
+	if limit.Type != config.UsageWithinLimit {
+		logger.Info(fmt.Sprintf("Message limit of session %s: %s", c.SessionKey, limit.Type))
+	}
+	config.Usage.Record(c.SessionKey, c.orgID, usage)
+}
+
+// recordRateLimited marks the session as exceeded after a 429, reading the reset time from
+// the error message when it carries the message_limit JSON
+func (c *Client) recordRateLimited(body []byte) {
+	limit := MessageLimit{Type: config.UsageExceededLimit}
+	var result struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &result) == nil {
+		var detail MessageLimit
+		if json.Unmarshal([]byte(result.Error.Message), &detail) == nil && detail.ResetsAt != nil {
+			limit.ResetsAt = detail.ResetsAt
+		}
+	}
+	c.recordMessageLimit(limit)
+}
+
+// SetRateLimitHeaders exposes the last known quota of the session as x-ratelimit-* headers.
+// It must be called before the response status is written.
+func (c *Client) SetRateLimitHeaders(gc *gin.Context) {
+	limit, ok := config.Usage.Get(c.SessionKey, c.orgID)
+	if !ok {
+		return
+	}
+	header := gc.Writer.Header()
+	header.Set("x-ratelimit-status", limit.Type)
+	if limit.Remaining != nil {
+		header.Set("x-ratelimit-remaining-requests", strconv.Itoa(*limit.Remaining))
+	}
+	if limit.ResetsAt != nil {
+		reset := time.Until(*limit.ResetsAt).Round(time.Second)
+		if reset < 0 {
+			reset = 0
+		}
+		header.Set("x-ratelimit-reset-requests", reset.String())
+	}
+}
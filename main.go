@@ -3,6 +3,7 @@ package main
 import (
 	"claude2api/config"
 	"claude2api/router"
+	"claude2api/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,6 +12,9 @@ func main() {
 	r := gin.Default()
 	// Load configuration
 
+	// Expand sessions configured with allOrgs into one pool member per organization
+	service.ExpandSessions()
+
 	// Setup all routes
 	router.SetupRoutes(r)
 
@@ -18,13 +18,15 @@ func AuthMiddleware() gin.HandlerFunc {
 		Key := c.GetHeader("Authorization")
 		if Key != "" {
 			Key = strings.TrimPrefix(Key, "Bearer ")
-			if Key != config.ConfigInstance.APIKey {
+			entry, ok := config.ConfigInstance.FindAPIKey(Key)
+			if !ok {
 				c.JSON(401, gin.H{
 					"error": "Invalid API key",
 				})
 				c.Abort()
 				return
 			}
+			c.Set("APIKeyConfig", entry)
 			c.Next()
 			return
 		}
@@ -34,3 +36,17 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Abort()
 	}
 }
+
+// AdminMiddleware only lets API keys with admin rights reach the admin endpoints
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if entry, ok := c.Get("APIKeyConfig"); ok && entry.(config.APIKeyConfig).Admin {
+			c.Next()
+			return
+		}
+		c.JSON(403, gin.H{
+			"error": "Admin API key required",
+		})
+		c.Abort()
+	}
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Artifact 是上游 artifacts 工具生成的结构化内容，已应用 update/rewrite
+type Artifact struct {
+	ID         string `json:"id"`         // 代理生成的资源 ID，可通过 /v1/artifacts/{id} 获取
+	Identifier string `json:"identifier"` // 模型给出的标识符
+	Title      string `json:"title,omitempty"`
+	Type       string `json:"type,omitempty"`
+	Language   string `json:"language,omitempty"`
+	Content    string `json:"content"`
+}
+
+// ToolCall 对应 OpenAI 消息中的 tool_calls 项
+type ToolCall struct {
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ArtifactFunctionName 是 tool_calls 模式下 artifact 工具调用的函数名，请求必须声明同名工具
+const ArtifactFunctionName = "artifact"
+
+// ArtifactToolCall 把 artifact 表示为名为 artifact 的工具调用
+func ArtifactToolCall(index int, artifact Artifact) ToolCall {
+	arguments, _ := json.Marshal(artifact)
+	return ToolCall{
+		Index:    &index,
+		ID:       "call_" + artifact.ID,
+		Type:     "function",
+		Function: FunctionCall{Name: ArtifactFunctionName, Arguments: string(arguments)},
+	}
+}
+
+// ReturnOpenAIArtifactChunk 发送一个 artifact 流式块，asToolCall 时以 tool_calls 增量发送
+func ReturnOpenAIArtifactChunk(artifact Artifact, toolCallIndex int, asToolCall bool, gc *gin.Context) error {
+	delta := Delta{}
+	if asToolCall {
+		delta.ToolCalls = []ToolCall{ArtifactToolCall(toolCallIndex, artifact)}
+	} else {
+		delta.Artifacts = []Artifact{artifact}
+	}
+	return writeStreamChunk(StreamChoice{Index: 0, Delta: delta}, gc)
+}
+
+// MessageExtras 是非流式响应中除文本外的结构化内容
+type MessageExtras struct {
+	Artifacts   []Artifact
+	AsToolCalls bool // artifacts 以 tool_calls 返回
+	Annotations []Annotation
+	WebSearch   *WebSearch
+}
+
+// ReturnOpenAIMessage 返回附带 artifacts、annotations 和搜索信息的非流式响应
+func ReturnOpenAIMessage(text string, finishReason string, extras MessageExtras, gc *gin.Context) error {
+	message := Message{
+		Role:        "assistant",
+		Content:     text,
+		Annotations: extras.Annotations,
+		WebSearch:   extras.WebSearch,
+	}
+	if message.Annotations == nil {
+		message.Annotations = []Annotation{}
+	}
+	artifacts := extras.Artifacts
+	if extras.AsToolCalls {
+		for i, artifact := range artifacts {
+			call := ArtifactToolCall(i, artifact)
+			call.Index = nil
+			message.ToolCalls = append(message.ToolCalls, call)
+		}
+	} else {
+		message.Artifacts = artifacts
+	}
+	gc.JSON(200, &OpenAIResponse{
+		ID:      uuid.New().String(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   "claude-3-7-sonnet-20250219",
+		Choices: []NoStreamChoice{
+			{
+				Index:        0,
+				Message:      message,
+				FinishReason: finishReason,
+			},
+		},
+	})
+	return nil
+}
@@ -0,0 +1,33 @@
+package model
+
+import "github.com/gin-gonic/gin"
+
+// Annotation 对应 OpenAI 消息中的 annotations 项，目前只有 url_citation
+type Annotation struct {
+	Type        string      `json:"type"`
+	URLCitation URLCitation `json:"url_citation"`
+}
+
+// URLCitation 标注 content 中引用网页的片段，索引按字符计算
+type URLCitation struct {
+	URL        string `json:"url"`
+	Title      string `json:"title"`
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
+}
+
+// WebSearch 是 claude.ai 网页搜索使用的查询和返回的结果
+type WebSearch struct {
+	Queries []string       `json:"queries,omitempty"`
+	Results []SearchResult `json:"results,omitempty"`
+}
+
+type SearchResult struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+}
+
+// ReturnOpenAIAnnotationChunk 发送引用或搜索信息的流式块
+func ReturnOpenAIAnnotationChunk(annotations []Annotation, search *WebSearch, gc *gin.Context) error {
+	return writeStreamChunk(StreamChoice{Index: 0, Delta: Delta{Annotations: annotations, WebSearch: search}}, gc)
+}
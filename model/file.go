@@ -0,0 +1,8 @@
+package model
+
+// FileInput 是请求中附带的一个已解码文件
+type FileInput struct {
+	FileName    string
+	ContentType string
+	Data        []byte
+}
@@ -15,6 +15,77 @@ type ChatCompletionRequest struct {
 	Messages []map[string]interface{} `json:"messages"`
 	Stream   bool                     `json:"stream"`
 	Tools    []map[string]interface{} `json:"tools,omitempty"`
+	// 超长上下文处理策略覆盖：file、recent、none
+	ContextStrategy  string          `json:"context_strategy,omitempty"`
+	ContextKeepTurns int             `json:"context_keep_turns,omitempty"`
+	ResponseFormat   *ResponseFormat `json:"response_format,omitempty"`
+	// 停止序列：字符串或字符串数组
+	Stop                interface{} `json:"stop,omitempty"`
+	MaxTokens           int         `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int         `json:"max_completion_tokens,omitempty"`
+	// 生成的选项数，大于 1 时并行运行多个对话
+	N int `json:"n,omitempty"`
+	// artifact 返回方式覆盖：markdown、structured、tool_calls、resource
+	ArtifactMode string `json:"artifact_mode,omitempty"`
+	// claude.ai 工具开关，未设置时使用 API 密钥的默认值；模型后缀 -search/-nosearch 设置 WebSearch
+	WebSearch *bool `json:"web_search,omitempty"`
+	Artifacts *bool `json:"artifacts,omitempty"`
+	REPL      *bool `json:"repl,omitempty"`
+	// 写作风格：内置风格、配置中的风格或账号中的风格
+	Style string `json:"style,omitempty"`
+	// 思考控制：OpenAI 的 reasoning_effort 或 Anthropic 的 thinking，-think 后缀仍然有效
+	ReasoningEffort string          `json:"reasoning_effort,omitempty"`
+	Thinking        *ThinkingConfig `json:"thinking,omitempty"`
+	// 时区和语言覆盖，未设置时使用 X-Timezone/Accept-Language 请求头，其次是 session 和全局设置
+	Timezone string `json:"timezone,omitempty"`
+	Locale   string `json:"locale,omitempty"`
+	// claude.ai 项目的 uuid 或名称，对话在项目中创建，只使用能访问该项目的 session
+	Project string `json:"project,omitempty"`
+}
+
+// StopSequences 返回非空的停止序列
+func (r *ChatCompletionRequest) StopSequences() []string {
+	var stops []string
+	switch stop := r.Stop.(type) {
+	case string:
+		if stop != "" {
+			stops = append(stops, stop)
+		}
+	case []interface{}:
+		for _, s := range stop {
+			if str, ok := s.(string); ok && str != "" {
+				stops = append(stops, str)
+			}
+		}
+	}
+	return stops
+}
+
+// TokenLimit 返回输出 token 上限，max_completion_tokens 优先，0 表示不限制
+func (r *ChatCompletionRequest) TokenLimit() int {
+	if r.MaxCompletionTokens > 0 {
+		return r.MaxCompletionTokens
+	}
+	return r.MaxTokens
+}
+
+// ThinkingConfig 对应 Anthropic 的 thinking 参数
+type ThinkingConfig struct {
+	Type         string `json:"type"` // enabled 或 disabled
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+// ResponseFormat 对应 OpenAI 的 response_format：text、json_object 或 json_schema
+type ResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+type JSONSchemaFormat struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Schema      map[string]interface{} `json:"schema,omitempty"`
+	Strict      bool                   `json:"strict,omitempty"`
 }
 
 // OpenAISrteamResponse 定义 OpenAI 的流式响应结构
@@ -43,13 +114,20 @@ type NoStreamChoice struct {
 
 // Delta 结构用于存储返回的文本内容
 type Delta struct {
-	Content string `json:"content"`
+	Content     string       `json:"content"`
+	Artifacts   []Artifact   `json:"artifacts,omitempty"`
+	ToolCalls   []ToolCall   `json:"tool_calls,omitempty"`
+	Annotations []Annotation `json:"annotations,omitempty"`
+	WebSearch   *WebSearch   `json:"web_search,omitempty"`
 }
 type Message struct {
-	Role       string        `json:"role"`
-	Content    string        `json:"content"`
-	Refusal    interface{}   `json:"refusal"`
-	Annotation []interface{} `json:"annotation"`
+	Role        string       `json:"role"`
+	Content     string       `json:"content"`
+	Refusal     interface{}  `json:"refusal"`
+	Annotations []Annotation `json:"annotations"`
+	Artifacts   []Artifact   `json:"artifacts,omitempty"`
+	ToolCalls   []ToolCall   `json:"tool_calls,omitempty"`
+	WebSearch   *WebSearch   `json:"web_search,omitempty"`
 }
 
 type OpenAIResponse struct {
@@ -74,22 +152,125 @@ func ReturnOpenAIResponse(text string, stream bool, gc *gin.Context) error {
 	}
 }
 
+// ReturnOpenAIFullResponse 返回已完整生成的文本，流式请求时一次性发送并结束事件流
+func ReturnOpenAIFullResponse(text string, stream bool, gc *gin.Context) error {
+	if !stream {
+		return noStreamResponse(text, gc)
+	}
+	StartStream(gc)
+	if err := ReturnOpenAIFinish(text, "stop", true, gc); err != nil {
+		return err
+	}
+	gc.Writer.Write([]byte("data: [DONE]\n\n"))
+	gc.Writer.Flush()
+	return nil
+}
+
+// StartStream 设置事件流响应头并发送 200 状态码
+func StartStream(gc *gin.Context) {
+	gc.Writer.Header().Set("Content-Type", "text/event-stream")
+	gc.Writer.Header().Set("Cache-Control", "no-cache")
+	gc.Writer.Header().Set("Connection", "keep-alive")
+	gc.Writer.WriteHeader(200)
+	gc.Writer.Flush()
+}
+
+// ChoiceResult 是 n > 1 时单个选项的完整结果
+type ChoiceResult struct {
+	Index        int
+	Text         string
+	FinishReason string
+	Artifacts    []Artifact
+	Annotations  []Annotation
+}
+
+// ReturnOpenAIChoiceChunk 发送指定选项的流式块，finishReason 非空时再发送该选项的结束块
+func ReturnOpenAIChoiceChunk(index int, text string, finishReason string, gc *gin.Context) error {
+	if text != "" {
+		if err := writeStreamChunk(StreamChoice{Index: index, Delta: Delta{Content: text}}, gc); err != nil {
+			return err
+		}
+	}
+	if finishReason == "" {
+		return nil
+	}
+	return writeStreamChunk(StreamChoice{Index: index, Delta: Delta{}, FinishReason: finishReason}, gc)
+}
+
+// ReturnOpenAIChoiceExtras 发送指定选项的 artifacts 和 annotations 流式块，没有时不发送
+func ReturnOpenAIChoiceExtras(index int, artifacts []Artifact, annotations []Annotation, gc *gin.Context) error {
+	if len(artifacts) == 0 && len(annotations) == 0 {
+		return nil
+	}
+	return writeStreamChunk(StreamChoice{Index: index, Delta: Delta{Artifacts: artifacts, Annotations: annotations}}, gc)
+}
+
+// ReturnOpenAIStreamError 在已开始的事件流中发送错误事件，客户端据此放弃整个响应
+func ReturnOpenAIStreamError(message string, gc *gin.Context) error {
+	jsonBytes, err := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{"message": message, "type": "upstream_error"},
+	})
+	if err != nil {
+		return err
+	}
+	gc.Writer.Write(append(append([]byte("data: "), jsonBytes...), []byte("\n\n")...))
+	gc.Writer.Flush()
+	return nil
+}
+
+// ReturnOpenAIChoices 返回包含多个选项的非流式响应，选项保留各自的下标
+func ReturnOpenAIChoices(results []ChoiceResult, gc *gin.Context) error {
+	choices := make([]NoStreamChoice, len(results))
+	for i, result := range results {
+		annotations := result.Annotations
+		if annotations == nil {
+			annotations = []Annotation{}
+		}
+		choices[i] = NoStreamChoice{
+			Index: result.Index,
+			Message: Message{
+				Role:        "assistant",
+				Content:     result.Text,
+				Annotations: annotations,
+				Artifacts:   result.Artifacts,
+			},
+			FinishReason: result.FinishReason,
+		}
+	}
+	gc.JSON(200, &OpenAIResponse{
+		ID:      uuid.New().String(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   "claude-3-7-sonnet-20250219",
+		Choices: choices,
+	})
+	return nil
+}
+
+// ReturnOpenAIFinish 结束响应：流式时发送剩余文本和带 finish_reason 的结束块，非流式时返回完整文本
+func ReturnOpenAIFinish(text string, finishReason string, stream bool, gc *gin.Context) error {
+	if !stream {
+		return noStreamResponseWithReason(text, finishReason, gc)
+	}
+	if text != "" {
+		if err := streamRespose(text, gc); err != nil {
+			return err
+		}
+	}
+	return writeStreamChunk(StreamChoice{Index: 0, Delta: Delta{}, FinishReason: finishReason}, gc)
+}
+
 func streamRespose(text string, gc *gin.Context) error {
+	return writeStreamChunk(StreamChoice{Index: 0, Delta: Delta{Content: text}}, gc)
+}
+
+func writeStreamChunk(choice StreamChoice, gc *gin.Context) error {
 	openAIResp := &OpenAISrteamResponse{
 		ID:      uuid.New().String(),
 		Object:  "chat.completion.chunk",
 		Created: time.Now().Unix(),
 		Model:   "claude-3-7-sonnet-20250219",
-		Choices: []StreamChoice{
-			{
-				Index: 0,
-				Delta: Delta{
-					Content: text,
-				},
-				Logprobs:     nil,
-				FinishReason: nil,
-			},
-		},
+		Choices: []StreamChoice{choice},
 	}
 
 	jsonBytes, err := json.Marshal(openAIResp)
@@ -107,6 +288,10 @@ func streamRespose(text string, gc *gin.Context) error {
 }
 
 func noStreamResponse(text string, gc *gin.Context) error {
+	return noStreamResponseWithReason(text, "stop", gc)
+}
+
+func noStreamResponseWithReason(text string, finishReason string, gc *gin.Context) error {
 	openAIResp := &OpenAIResponse{
 		ID:      uuid.New().String(),
 		Object:  "chat.completion",
@@ -116,11 +301,12 @@ func noStreamResponse(text string, gc *gin.Context) error {
 			{
 				Index: 0,
 				Message: Message{
-					Role:    "assistant",
-					Content: text,
+					Role:        "assistant",
+					Content:     text,
+					Annotations: []Annotation{},
 				},
 				Logprobs:     nil,
-				FinishReason: "stop",
+				FinishReason: finishReason,
 			},
 		},
 	}
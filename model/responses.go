@@ -0,0 +1,348 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ResponsesRequest 对应 OpenAI Responses API 的请求。
+// 请求体同时按 ChatCompletionRequest 解析，style、web_search、project 等扩展字段同样有效。
+type ResponsesRequest struct {
+	Model              string            `json:"model"`
+	Input              interface{}       `json:"input"` // 字符串或输入项数组
+	Instructions       string            `json:"instructions,omitempty"`
+	PreviousResponseID string            `json:"previous_response_id,omitempty"`
+	Stream             bool              `json:"stream"`
+	Store              *bool             `json:"store,omitempty"` // 未设置时保存
+	MaxOutputTokens    int               `json:"max_output_tokens,omitempty"`
+	Reasoning          *ReasoningConfig  `json:"reasoning,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+}
+
+// ReasoningConfig 对应 Responses API 的 reasoning 参数
+type ReasoningConfig struct {
+	Effort  string `json:"effort,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// Response 是 Responses API 的响应对象
+type Response struct {
+	ID                 string             `json:"id"`
+	Object             string             `json:"object"`
+	CreatedAt          int64              `json:"created_at"`
+	Status             string             `json:"status"` // in_progress、completed、incomplete、failed
+	Model              string             `json:"model"`
+	Instructions       *string            `json:"instructions"`
+	PreviousResponseID *string            `json:"previous_response_id"`
+	Output             []interface{}      `json:"output"` // *ResponseMessage 或 *ResponseReasoning
+	IncompleteDetails  *IncompleteDetails `json:"incomplete_details"`
+	Error              *ResponseError     `json:"error"`
+	Usage              *ResponseUsage     `json:"usage"`
+	Store              bool               `json:"store"`
+	Metadata           map[string]string  `json:"metadata"`
+}
+
+// ResponseMessage 是 assistant 回复的输出项
+type ResponseMessage struct {
+	ID      string       `json:"id"`
+	Type    string       `json:"type"`
+	Status  string       `json:"status"`
+	Role    string       `json:"role"`
+	Content []OutputText `json:"content"`
+}
+
+// OutputText 是回复中的文本
+type OutputText struct {
+	Type        string               `json:"type"`
+	Text        string               `json:"text"`
+	Annotations []ResponseAnnotation `json:"annotations"`
+}
+
+// ResponseAnnotation 是 output_text 中的 url_citation 标注，索引按字符计算，相对于该段文本
+type ResponseAnnotation struct {
+	Type       string `json:"type"`
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
+	URL        string `json:"url"`
+	Title      string `json:"title"`
+}
+
+// ResponseReasoning 是思考内容的输出项
+type ResponseReasoning struct {
+	ID      string        `json:"id"`
+	Type    string        `json:"type"`
+	Summary []SummaryText `json:"summary"`
+}
+
+// SummaryText 是思考内容的文本
+type SummaryText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type IncompleteDetails struct {
+	Reason string `json:"reason"`
+}
+
+type ResponseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ResponseUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// NewResponse 创建进行中的响应对象
+func NewResponse(model string, req *ResponsesRequest) *Response {
+	resp := &Response{
+		ID:        "resp_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Object:    "response",
+		CreatedAt: time.Now().Unix(),
+		Status:    "in_progress",
+		Model:     model,
+		Output:    []interface{}{},
+		Store:     req.Store == nil || *req.Store,
+		Metadata:  req.Metadata,
+	}
+	if req.Instructions != "" {
+		resp.Instructions = &req.Instructions
+	}
+	if req.PreviousResponseID != "" {
+		resp.PreviousResponseID = &req.PreviousResponseID
+	}
+	if resp.Metadata == nil {
+		resp.Metadata = map[string]string{}
+	}
+	return resp
+}
+
+// OutputText 返回所有 assistant 输出项的文本
+func (r *Response) OutputText() string {
+	var text strings.Builder
+	for _, item := range r.Output {
+		if message, ok := item.(*ResponseMessage); ok {
+			for _, part := range message.Content {
+				text.WriteString(part.Text)
+			}
+		}
+	}
+	return text.String()
+}
+
+// ResponseStream 把思考和回复文本写成 Responses API 的输出项。流式时发送语义事件，
+// 事件流在第一个事件时才开始，之前失败仍可换 session 重试；非流式时在 Finish 中返回完整响应。
+type ResponseStream struct {
+	gc       *gin.Context
+	stream   bool
+	response *Response
+	started  bool
+	seq      int
+	// 当前打开的输出项
+	reasoning *ResponseReasoning
+	message   *ResponseMessage
+	text      strings.Builder
+	// 已关闭的 message 输出项的字符数，引用的索引按全部回复文本计算
+	offset      int
+	annotations []ResponseAnnotation
+}
+
+func NewResponseStream(response *Response, stream bool, gc *gin.Context) *ResponseStream {
+	return &ResponseStream{gc: gc, stream: stream, response: response}
+}
+
+// Started 报告是否已经向客户端发送了事件
+func (s *ResponseStream) Started() bool {
+	return s.started
+}
+
+// Reasoning 追加思考内容，必要时关闭回复并打开新的 reasoning 输出项
+func (s *ResponseStream) Reasoning(text string) {
+	if text == "" {
+		return
+	}
+	if s.reasoning == nil {
+		s.closeItem()
+		s.start()
+		s.reasoning = &ResponseReasoning{ID: "rs_" + itemID(), Type: "reasoning", Summary: []SummaryText{}}
+		s.addItem(s.reasoning)
+		s.event("response.reasoning_summary_part.added", gin.H{
+			"item_id": s.reasoning.ID, "output_index": s.outputIndex(), "summary_index": 0,
+			"part": SummaryText{Type: "summary_text", Text: ""},
+		})
+	}
+	s.text.WriteString(text)
+	s.event("response.reasoning_summary_text.delta", gin.H{
+		"item_id": s.reasoning.ID, "output_index": s.outputIndex(), "summary_index": 0, "delta": text,
+	})
+}
+
+// Text 追加回复文本，必要时关闭思考并打开新的 message 输出项
+func (s *ResponseStream) Text(text string) {
+	if text == "" {
+		return
+	}
+	if s.message == nil {
+		s.closeItem()
+		s.start()
+		s.message = &ResponseMessage{ID: "msg_" + itemID(), Type: "message", Status: "in_progress", Role: "assistant", Content: []OutputText{}}
+		s.addItem(s.message)
+		s.event("response.content_part.added", gin.H{
+			"item_id": s.message.ID, "output_index": s.outputIndex(), "content_index": 0,
+			"part": OutputText{Type: "output_text", Text: "", Annotations: []ResponseAnnotation{}},
+		})
+	}
+	s.text.WriteString(text)
+	s.event("response.output_text.delta", gin.H{
+		"item_id": s.message.ID, "output_index": s.outputIndex(), "content_index": 0, "delta": text,
+	})
+}
+
+// Citation 把引用添加到当前 message 输出项，索引按全部回复文本计算。
+// 引用开始于之前已关闭的输出项时丢弃。
+func (s *ResponseStream) Citation(annotation Annotation) {
+	if s.message == nil || annotation.URLCitation.StartIndex < s.offset {
+		return
+	}
+	a := ResponseAnnotation{
+		Type:       "url_citation",
+		StartIndex: annotation.URLCitation.StartIndex - s.offset,
+		EndIndex:   annotation.URLCitation.EndIndex - s.offset,
+		URL:        annotation.URLCitation.URL,
+		Title:      annotation.URLCitation.Title,
+	}
+	s.annotations = append(s.annotations, a)
+	s.event("response.output_text.annotation.added", gin.H{
+		"item_id": s.message.ID, "output_index": s.outputIndex(), "content_index": 0,
+		"annotation_index": len(s.annotations) - 1, "annotation": a,
+	})
+}
+
+// Finish 关闭当前输出项并结束响应，reason 为 length 时状态为 incomplete
+func (s *ResponseStream) Finish(reason string, usage ResponseUsage) {
+	s.closeItem()
+	s.response.Usage = &usage
+	s.response.Status = "completed"
+	if reason == "length" {
+		s.response.Status = "incomplete"
+		s.response.IncompleteDetails = &IncompleteDetails{Reason: "max_output_tokens"}
+	}
+	if !s.stream {
+		s.gc.JSON(200, s.response)
+		return
+	}
+	s.start()
+	s.event("response."+s.response.Status, gin.H{"response": s.response})
+}
+
+// Fail 在事件流开始后出错时结束响应
+func (s *ResponseStream) Fail(message string) {
+	s.closeItem()
+	s.response.Status = "failed"
+	s.response.Error = &ResponseError{Code: "server_error", Message: message}
+	if !s.stream {
+		s.gc.JSON(500, s.response)
+		return
+	}
+	s.start()
+	s.event("response.failed", gin.H{"response": s.response})
+}
+
+// start 开始事件流并发送 response.created 和 response.in_progress
+func (s *ResponseStream) start() {
+	if !s.stream || s.started {
+		return
+	}
+	StartStream(s.gc)
+	s.started = true
+	s.event("response.created", gin.H{"response": s.response})
+	s.event("response.in_progress", gin.H{"response": s.response})
+}
+
+func (s *ResponseStream) outputIndex() int {
+	return len(s.response.Output) - 1
+}
+
+func (s *ResponseStream) addItem(item interface{}) {
+	s.response.Output = append(s.response.Output, item)
+	s.event("response.output_item.added", gin.H{"output_index": s.outputIndex(), "item": item})
+}
+
+// closeItem 写入当前输出项的完整文本并发送 done 事件
+func (s *ResponseStream) closeItem() {
+	text := s.text.String()
+	s.text.Reset()
+	switch {
+	case s.reasoning != nil:
+		item := s.reasoning
+		s.reasoning = nil
+		part := SummaryText{Type: "summary_text", Text: text}
+		item.Summary = []SummaryText{part}
+		s.event("response.reasoning_summary_text.done", gin.H{
+			"item_id": item.ID, "output_index": s.outputIndex(), "summary_index": 0, "text": text,
+		})
+		s.event("response.reasoning_summary_part.done", gin.H{
+			"item_id": item.ID, "output_index": s.outputIndex(), "summary_index": 0, "part": part,
+		})
+		s.event("response.output_item.done", gin.H{"output_index": s.outputIndex(), "item": item})
+	case s.message != nil:
+		item := s.message
+		s.message = nil
+		part := OutputText{Type: "output_text", Text: text, Annotations: clampResponseAnnotations(s.annotations, text)}
+		s.annotations = nil
+		s.offset += utf8.RuneCountInString(text)
+		item.Content = []OutputText{part}
+		item.Status = "completed"
+		s.event("response.output_text.done", gin.H{
+			"item_id": item.ID, "output_index": s.outputIndex(), "content_index": 0, "text": text,
+		})
+		s.event("response.content_part.done", gin.H{
+			"item_id": item.ID, "output_index": s.outputIndex(), "content_index": 0, "part": part,
+		})
+		s.event("response.output_item.done", gin.H{"output_index": s.outputIndex(), "item": item})
+	}
+}
+
+// clampResponseAnnotations 保留文本范围内的引用，文本可能被输出限制截断
+func clampResponseAnnotations(annotations []ResponseAnnotation, text string) []ResponseAnnotation {
+	length := utf8.RuneCountInString(text)
+	kept := make([]ResponseAnnotation, 0, len(annotations))
+	for _, a := range annotations {
+		if a.StartIndex >= length {
+			continue
+		}
+		if a.EndIndex > length {
+			a.EndIndex = length
+		}
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+// event 发送一个带序号的语义事件，非流式时不发送
+func (s *ResponseStream) event(eventType string, data gin.H) {
+	if !s.stream || !s.started {
+		return
+	}
+	data["type"] = eventType
+	data["sequence_number"] = s.seq
+	s.seq++
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(s.gc.Writer, "event: %s\ndata: %s\n\n", eventType, payload)
+	s.gc.Writer.Flush()
+}
+
+func itemID() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
+}
@@ -18,11 +18,35 @@ func SetupRoutes(r *gin.Engine) {
 
 	// Chat completions endpoint (OpenAI-compatible)
 	r.POST("/v1/chat/completions", service.ChatCompletionsHandler)
+	r.POST("/v1/chat/completions/dry-run", service.DryRunHandler)
 	r.GET("/v1/models", service.MoudlesHandler)
+	r.GET("/v1/artifacts/:id", service.ArtifactHandler)
+	// Responses API (OpenAI-compatible)
+	r.POST("/v1/responses", service.ResponsesHandler)
+	r.GET("/v1/responses/:id", service.GetResponseHandler)
+	r.DELETE("/v1/responses/:id", service.DeleteResponseHandler)
 
 	if config.ConfigInstance.EnableMirrorApi {
 		r.POST(config.ConfigInstance.MirrorApiPrefix+"/v1/chat/completions", service.MirrorChatHandler)
+		r.POST(config.ConfigInstance.MirrorApiPrefix+"/v1/chat/completions/dry-run", service.DryRunHandler)
 		r.GET(config.ConfigInstance.MirrorApiPrefix+"/v1/models", service.MoudlesHandler)
+		r.GET(config.ConfigInstance.MirrorApiPrefix+"/v1/artifacts/:id", service.ArtifactHandler)
+		r.POST(config.ConfigInstance.MirrorApiPrefix+"/v1/responses", service.ResponsesHandler)
+		r.GET(config.ConfigInstance.MirrorApiPrefix+"/v1/responses/:id", service.GetResponseHandler)
+		r.DELETE(config.ConfigInstance.MirrorApiPrefix+"/v1/responses/:id", service.DeleteResponseHandler)
+	}
+
+	// Admin endpoints
+	adminRouter := r.Group("/admin", middleware.AdminMiddleware())
+	{
+		adminRouter.GET("/orgs", service.AdminOrgsHandler)
+		adminRouter.GET("/sessions", service.AdminSessionsHandler)
+		adminRouter.GET("/file-cache", service.AdminFileCacheHandler)
+		adminRouter.GET("/styles", service.AdminStylesHandler)
+		adminRouter.GET("/projects", service.AdminProjectsHandler)
+		adminRouter.GET("/projects/:project/docs", service.AdminProjectDocsHandler)
+		adminRouter.POST("/projects/:project/docs", service.AdminUploadProjectDocHandler)
+		adminRouter.DELETE("/projects/:project/docs/:doc", service.AdminDeleteProjectDocHandler)
 	}
 
 	// HuggingFace compatible routes
@@ -31,6 +55,7 @@ func SetupRoutes(r *gin.Engine) {
 		v1Router := hfRouter.Group("/v1")
 		{
 			v1Router.POST("/chat/completions", service.ChatCompletionsHandler)
+			v1Router.POST("/responses", service.ResponsesHandler)
 			v1Router.GET("/models", service.MoudlesHandler)
 		}
 	}
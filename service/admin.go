@@ -0,0 +1,143 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionOrgs 是管理接口中单个 session key 可见的组织列表
+type SessionOrgs struct {
+	Session       string              `json:"session"`
+	SelectedOrgID []string            `json:"selected_org_ids"`
+	Organizations []core.Organization `json:"organizations"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// ExpandSessions 把配置了 allOrgs 的 session 展开为每个组织一个 session，启动时调用一次
+func ExpandSessions() {
+	sessions := config.ConfigInstance.Sessions
+	expanded := make([]config.SessionInfo, 0, len(sessions))
+	changed := false
+	for _, session := range sessions {
+		if !session.AllOrgs {
+			expanded = append(expanded, session)
+			continue
+		}
+		changed = true
+		client := core.NewClient(session.SessionKey, config.ConfigInstance.Proxy, "")
+		orgs, err := client.GetOrganizations()
+		if err != nil {
+			// 展开失败时保留原 session，按默认策略解析组织
+			logger.Error(fmt.Sprintf("Failed to list organizations for session %s: %v", maskSessionKey(session.SessionKey), err))
+			session.AllOrgs = false
+			expanded = append(expanded, session)
+			continue
+		}
+		for _, org := range orgs {
+			member := session
+			member.AllOrgs = false
+			member.OrgID = org.UUID
+			expanded = append(expanded, member)
+			logger.Info(fmt.Sprintf("Expanded session %s to org %s (%s, %s)", maskSessionKey(session.SessionKey), org.UUID, org.Name, org.RateLimitTier))
+		}
+	}
+	if changed {
+		config.ConfigInstance.ReplaceSessions(expanded)
+		logger.Info(fmt.Sprintf("Session pool size after org expansion: %d", len(expanded)))
+	}
+}
+
+// AdminOrgsHandler 列出每个 session key 可见的所有组织
+func AdminOrgsHandler(c *gin.Context) {
+	config.ConfigInstance.RwMutx.RLock()
+	sessions := make([]config.SessionInfo, len(config.ConfigInstance.Sessions))
+	copy(sessions, config.ConfigInstance.Sessions)
+	config.ConfigInstance.RwMutx.RUnlock()
+
+	var order []string
+	selected := map[string][]string{}
+	for _, session := range sessions {
+		if _, ok := selected[session.SessionKey]; !ok {
+			order = append(order, session.SessionKey)
+			selected[session.SessionKey] = []string{}
+		}
+		if session.OrgID != "" {
+			selected[session.SessionKey] = append(selected[session.SessionKey], session.OrgID)
+		}
+	}
+
+	result := make([]SessionOrgs, 0, len(order))
+	for _, sessionKey := range order {
+		item := SessionOrgs{
+			Session:       maskSessionKey(sessionKey),
+			SelectedOrgID: selected[sessionKey],
+			Organizations: []core.Organization{},
+		}
+		client := core.NewClient(sessionKey, config.ConfigInstance.Proxy, "")
+		orgs, err := client.GetOrganizations()
+		if err != nil {
+			item.Error = err.Error()
+		} else {
+			item.Organizations = orgs
+		}
+		result = append(result, item)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": result,
+	})
+}
+
+// AdminFileCacheHandler 返回上传文件复用缓存的命中统计
+func AdminFileCacheHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, core.UploadCache.Stats())
+}
+
+// maskSessionKey 隐藏 session key 的大部分内容，避免在管理接口中泄露
+func maskSessionKey(sessionKey string) string {
+	if len(sessionKey) <= 20 {
+		return "***"
+	}
+	return sessionKey[:16] + "..." + sessionKey[len(sessionKey)-4:]
+}
+
+// SessionStyles 是管理接口中单个 session 可用的写作风格
+type SessionStyles struct {
+	Session string       `json:"session"`
+	OrgID   string       `json:"org_id"`
+	Styles  []core.Style `json:"styles"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// AdminStylesHandler 列出每个 session 的组织中可用的风格，以及配置中定义的风格
+func AdminStylesHandler(c *gin.Context) {
+	config.ConfigInstance.RwMutx.RLock()
+	sessions := make([]config.SessionInfo, len(config.ConfigInstance.Sessions))
+	copy(sessions, config.ConfigInstance.Sessions)
+	config.ConfigInstance.RwMutx.RUnlock()
+
+	result := make([]SessionStyles, 0, len(sessions))
+	for _, session := range sessions {
+		item := SessionStyles{Session: maskSessionKey(session.SessionKey), Styles: []core.Style{}}
+		client, session, err := newSessionClient(session, "")
+		if err == nil {
+			item.OrgID = session.OrgID
+			var styles []core.Style
+			if styles, err = client.ListStyles(); err == nil {
+				item.Styles = styles
+			}
+		}
+		if err != nil {
+			item.Error = err.Error()
+		}
+		result = append(result, item)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":         result,
+		"proxy_styles": config.ConfigInstance.Styles,
+	})
+}
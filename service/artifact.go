@@ -0,0 +1,87 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/model"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// artifactModeFor 返回请求使用的 artifact 返回方式，请求参数优先于配置。
+// 请求没有声明 artifact 工具时，配置的 tool_calls 回退为 markdown，请求中指定时已在校验中拒绝。
+func artifactModeFor(req *model.ChatCompletionRequest) string {
+	if req.ArtifactMode != "" {
+		return req.ArtifactMode
+	}
+	if config.ConfigInstance.ArtifactMode == core.ArtifactModeToolCalls && !declaresArtifactTool(req) {
+		return core.ArtifactModeMarkdown
+	}
+	return config.ConfigInstance.ArtifactMode
+}
+
+// declaresArtifactTool 检查请求是否声明了名为 artifact 的函数工具，客户端据此执行 artifact 工具调用
+func declaresArtifactTool(req *model.ChatCompletionRequest) bool {
+	for _, tool := range req.Tools {
+		if tool["type"] != "function" {
+			continue
+		}
+		if function, ok := tool["function"].(map[string]interface{}); ok && function["name"] == model.ArtifactFunctionName {
+			return true
+		}
+	}
+	return false
+}
+
+// choiceArtifactMode 返回 n > 1 时的 artifact 返回方式。每个选项的 artifacts 随该选项返回，
+// 配置的 tool_calls 回退为 markdown，请求中指定时已在校验中拒绝。
+func choiceArtifactMode(req *model.ChatCompletionRequest) string {
+	if mode := artifactModeFor(req); mode != core.ArtifactModeToolCalls {
+		return mode
+	}
+	return core.ArtifactModeMarkdown
+}
+
+// ArtifactHandler 返回保存的 artifact，?raw=true 时以附件形式返回内容。
+// 内容由模型生成，HTML 和 SVG 不能在代理的源下直接渲染，否则构成存储型 XSS。
+func ArtifactHandler(c *gin.Context) {
+	artifact, ok := core.Artifacts.Get(c.Param("id"), requestOwner(c))
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Artifact not found",
+		})
+		return
+	}
+	if c.Query("raw") == "true" {
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": artifactFileName(artifact)}))
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("Content-Security-Policy", "sandbox; default-src 'none'")
+		c.Data(http.StatusOK, artifactContentType(artifact), []byte(artifact.Content))
+		return
+	}
+	c.JSON(http.StatusOK, artifact)
+}
+
+// artifactContentType 返回直接下载 artifact 时的 Content-Type
+func artifactContentType(artifact model.Artifact) string {
+	switch artifact.Type {
+	case "text/html", "text/markdown", "image/svg+xml":
+		return artifact.Type + "; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// artifactFileName 返回下载 artifact 时的文件名
+func artifactFileName(artifact model.Artifact) string {
+	switch artifact.Type {
+	case "text/html":
+		return artifact.ID + ".html"
+	case "text/markdown":
+		return artifact.ID + ".md"
+	case "image/svg+xml":
+		return artifact.ID + ".svg"
+	}
+	return artifact.ID + ".txt"
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"claude2api/model"
+	"claude2api/utils"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionPicker 返回第 choice 个选项第 attempt 次尝试使用的 session，attempts 为每个选项的尝试次数
+type sessionPicker struct {
+	attempts int
+	pick     func(choice, attempt int) (config.SessionInfo, error)
+}
+
+// poolSessionPicker 从 session 池的 base 之后选择 session，SpreadChoices 时每个选项从不同的 session 开始。
+// 指定项目时跳过无法访问该项目的 session。
+func poolSessionPicker(base int, n int, project string) sessionPicker {
+	return sessionPicker{
+		attempts: config.ConfigInstance.RetryCount,
+		pick: func(choice, attempt int) (config.SessionInfo, error) {
+			offset := attempt
+			if config.ConfigInstance.SpreadChoices {
+				offset = choice + attempt*n
+			}
+			session, _, err := nextSession(base+1+offset, project)
+			return session, err
+		},
+	}
+}
+
+// fixedSessionPicker 所有选项使用镜像请求自带的 session
+func fixedSessionPicker(session config.SessionInfo) sessionPicker {
+	return sessionPicker{
+		attempts: 1,
+		pick: func(int, int) (config.SessionInfo, error) {
+			return session, nil
+		},
+	}
+}
+
+// handleChoices 为 n > 1 的请求并行运行 n 个对话。流式时按选项编号交错发送块，
+// 非流式时合并为 choices。失败的选项被丢弃，全部失败时返回 false；
+// 流式响应中已发送内容的选项失败时无法丢弃，以错误事件结束整个响应。
+func handleChoices(c *gin.Context, sessions sessionPicker, modelName string, processor *utils.ChatRequestProcessor, req *model.ChatCompletionRequest) bool {
+	n := req.N
+	results := make([]model.ChoiceResult, n)
+	errs := make([]error, n)
+	emitted := make([]bool, n)
+	var mu sync.Mutex
+	started := false
+	// 流式响应在第一个块到达时才开始，全部失败时仍可返回错误状态码
+	write := func(send func()) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !started {
+			model.StartStream(c)
+			started = true
+		}
+		send()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			var text []string
+			emit := func(chunk string) {
+				emitted[index] = true
+				if req.Stream {
+					write(func() { model.ReturnOpenAIChoiceChunk(index, chunk, "", c) })
+				} else {
+					text = append(text, chunk)
+				}
+			}
+			output, err := runChoice(c, sessions, index, modelName, processor, req, emit)
+			if err != nil {
+				logger.Error(fmt.Sprintf("Choice %d failed: %v", index, err))
+				errs[index] = err
+				return
+			}
+			if req.Stream {
+				write(func() {
+					model.ReturnOpenAIChoiceExtras(index, output.Artifacts, output.Annotations, c)
+					model.ReturnOpenAIChoiceChunk(index, "", output.FinishReason, c)
+				})
+			}
+			results[index] = model.ChoiceResult{
+				Index:        index,
+				Text:         strings.Join(text, ""),
+				FinishReason: output.FinishReason,
+				Artifacts:    output.Artifacts,
+				Annotations:  output.Annotations,
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var succeeded []model.ChoiceResult
+	var partial error
+	for i, result := range results {
+		if errs[i] == nil {
+			succeeded = append(succeeded, result)
+		} else if req.Stream && emitted[i] && partial == nil {
+			partial = fmt.Errorf("choice %d failed: %v", i, errs[i])
+		}
+	}
+	if len(succeeded) == 0 && !started {
+		for _, err := range errs {
+			if respondProjectError(c, err) {
+				return true
+			}
+		}
+		return false
+	}
+	if !req.Stream {
+		model.ReturnOpenAIChoices(succeeded, c)
+		return true
+	}
+	mu.Lock()
+	if partial != nil {
+		model.ReturnOpenAIStreamError(partial.Error(), c)
+	}
+	c.Writer.Write([]byte("data: [DONE]\n\n"))
+	c.Writer.Flush()
+	mu.Unlock()
+	return true
+}
+
+// runChoice 在新对话中生成一个选项，输出开始前失败时换 session 重试。
+// 上游在流中报告的错误同样返回 error，该选项被丢弃。
+func runChoice(c *gin.Context, sessions sessionPicker, index int, modelName string, processor *utils.ChatRequestProcessor, req *model.ChatCompletionRequest, emit func(string)) (core.ChoiceOutput, error) {
+	var lastErr error
+	for attempt := 0; attempt < sessions.attempts; attempt++ {
+		session, err := sessions.pick(index, attempt)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		logger.Info(fmt.Sprintf("Using session for choice %d of model %s: %s", index, modelName, maskSessionKey(session.SessionKey)))
+		claudeClient, conversationID, err := startConversation(session, modelName, processor, conversationOptionsFor(c, req))
+		if errors.Is(err, core.ErrProjectInvalid) {
+			// 项目错误由客户端修正，换 session 无济于事
+			return core.ChoiceOutput{}, err
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		claudeClient.SetOutputLimits(core.OutputLimits{Stop: req.StopSequences(), MaxTokens: req.TokenLimit()})
+		claudeClient.SetArtifactMode(choiceArtifactMode(req))
+		emitted := false
+		output, err := claudeClient.SendMessageChoice(conversationID, processor.Prompt.String(), c.Request.Context().Done(), func(text string) {
+			emitted = true
+			emit(text)
+		})
+		if err != nil || config.ConfigInstance.ChatDelete {
+			go cleanupConversation(claudeClient, conversationID, 3)
+		}
+		if err == nil {
+			return output, nil
+		}
+		lastErr = err
+		if emitted {
+			// 已发送部分内容，不能再换 session 重试
+			break
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no session available")
+	}
+	return core.ChoiceOutput{}, lastErr
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"claude2api/model"
+	"claude2api/utils"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// applyContextStrategy 处理超长上下文，并通过响应头告知客户端使用的策略
+func applyContextStrategy(c *gin.Context, processor *utils.ChatRequestProcessor, opts utils.ContextOptions) {
+	strategy := processor.ApplyContextStrategy(opts)
+	c.Header("X-Context-Strategy", strategy)
+	if processor.CompactedTurns > 0 {
+		c.Header("X-Context-Compacted-Turns", strconv.Itoa(processor.CompactedTurns))
+	}
+}
+
+// poolSummarizer 依次使用 session 池中的 session 总结历史
+func poolSummarizer(history []model.FileInput) (string, error) {
+	n := len(config.ConfigInstance.Sessions)
+	if n == 0 {
+		return "", errNoSessions
+	}
+	var lastErr error
+	index := config.Sr.NextIndex()
+	for i := 0; i < config.ConfigInstance.RetryCount; i++ {
+		index = (index + 1) % n
+		session, err := config.ConfigInstance.GetSessionForModel(index)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		summary, err := summarizeWithSession(session, history)
+		if err == nil {
+			return summary, nil
+		}
+		logger.Error(fmt.Sprintf("Failed to summarize history with session %s: %v", maskSessionKey(session.SessionKey), err))
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no session available")
+	}
+	return "", lastErr
+}
+
+// sessionSummarizer 使用镜像请求自带的 session 总结历史
+func sessionSummarizer(session config.SessionInfo) utils.Summarizer {
+	return func(history []model.FileInput) (string, error) {
+		return summarizeWithSession(session, history)
+	}
+}
+
+// summarizeWithSession 在独立的对话中用 CompactModel 总结历史，结束后删除该对话
+func summarizeWithSession(session config.SessionInfo, history []model.FileInput) (string, error) {
+	claudeClient, session, err := newSessionClient(session, config.ConfigInstance.CompactModel)
+	if err != nil {
+		return "", err
+	}
+	for _, doc := range history {
+		claudeClient.AddAttachment(doc.FileName, doc.ContentType, string(doc.Data))
+	}
+	conversationID, err := claudeClient.CreateConversation()
+	if err != nil {
+		if errors.Is(err, core.ErrOrgInvalid) {
+			config.ConfigInstance.InvalidateSessionOrgID(session.SessionKey, session.OrgID)
+		}
+		return "", err
+	}
+	defer func() { go cleanupConversation(claudeClient, conversationID, 3) }()
+	return claudeClient.SendMessageCollect(conversationID, utils.SummaryPrompt)
+}
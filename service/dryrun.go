@@ -0,0 +1,76 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/model"
+	"claude2api/utils"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dryRunFile 描述将要上传或作为附件发送的文件
+type dryRunFile struct {
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+}
+
+// DryRunHandler 渲染请求并返回将发送给 claude.ai 的提示词和文件，不调用上游。
+// compact 策略不会生成摘要，而是按回退后的策略展示。
+func DryRunHandler(c *gin.Context) {
+	req, err := parseAndValidateRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+
+	model := getModelOrDefault(req.Model)
+	processor, err := prepareProcessor(c, req, model)
+	if err != nil {
+		respondPrepareError(c, err)
+		return
+	}
+	strategy := processor.ApplyContextStrategy(contextOptionsFor(req, nil))
+	prompt := processor.Prompt.String()
+	if utils.IsJSONMode(req.ResponseFormat) {
+		prompt += utils.JSONInstruction(req.ResponseFormat)
+	}
+
+	templateName := processor.Template.Name
+	if templateName == "" {
+		templateName = "builtin"
+	}
+	tools := upstreamToolsFor(c, req)
+	thinking := strings.HasSuffix(model, "-think")
+	if enabled := thinkingFor(req, model); enabled != nil {
+		thinking = *enabled
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"model":            model,
+		"tools":            gin.H{"web_search": tools.WebSearch, "artifacts": tools.Artifacts, "repl": tools.REPL},
+		"style":            req.Style,
+		"thinking":         thinking,
+		"timezone":         firstString(req.Timezone, config.ConfigInstance.Timezone),
+		"locale":           firstString(req.Locale, config.ConfigInstance.Locale),
+		"project":          req.Project,
+		"template":         templateName,
+		"context_strategy": strategy,
+		"prompt":           prompt,
+		"estimated_tokens": utils.EstimateTokens(prompt),
+		"files":            dryRunFiles(processor.Files),
+		"attachments":      dryRunFiles(append(processor.ContextDocuments, processor.Documents...)),
+	})
+}
+
+func dryRunFiles(files []model.FileInput) []dryRunFile {
+	result := make([]dryRunFile, 0, len(files))
+	for _, file := range files {
+		result = append(result, dryRunFile{FileName: file.FileName, ContentType: file.ContentType, Size: len(file.Data)})
+	}
+	return result
+}
@@ -6,6 +6,7 @@ import (
 	"claude2api/logger"
 	"claude2api/model"
 	"claude2api/utils"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -32,18 +33,29 @@ func MoudlesHandler(c *gin.Context) {
 		{"id": "claude-opus-4-20250514"},
 	}
 
-	extendedModels := make([]map[string]interface{}, 0, len(models)*2)
+	extendedModels := make([]map[string]interface{}, 0, len(models)*2+len(config.ConfigInstance.ModelAliases))
 	for _, m := range models {
 		// 保留原有 id
+		id, _ := m["id"].(string)
+		m["supports_thinking"] = supportsThinking(id)
 		extendedModels = append(extendedModels, m)
 		// 追加 -think 版本
-		if id, ok := m["id"].(string); ok {
+		if supportsThinking(id) {
 			extendedModels = append(extendedModels, map[string]interface{}{
-				"id": id + "-think",
+				"id":                id + "-think",
+				"supports_thinking": true,
 			})
 		}
 	}
 
+	// 追加配置的模型别名
+	for name, alias := range config.ConfigInstance.ModelAliases {
+		extendedModels = append(extendedModels, map[string]interface{}{
+			"id":                name,
+			"supports_thinking": supportsThinking(alias.Model),
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"data": extendedModels,
 	})
@@ -67,37 +79,34 @@ func ChatCompletionsHandler(c *gin.Context) {
 	}
 
 	// Process messages into prompt and extract images
-	processor := utils.NewChatRequestProcessor()
-	processor.ProcessMessages(req.Messages)
-
-	// Get model or use default
 	model := getModelOrDefault(req.Model)
-	index := config.Sr.NextIndex()
-	// Attempt with retry mechanism
-	for i := 0; i < config.ConfigInstance.RetryCount; i++ {
-		index = (index + 1) % len(config.ConfigInstance.Sessions)
-		session, err := config.ConfigInstance.GetSessionForModel(index)
-		if err != nil {
-			logger.Error(fmt.Sprintf("Failed to get session for model %s: %v", model, err))
-			logger.Info("Retrying another session")
-			continue
-		}
+	processor, err := prepareProcessor(c, req, model)
+	if err != nil {
+		respondPrepareError(c, err)
+		return
+	}
 
-		logger.Info(fmt.Sprintf("Using session for model %s: %s", model, session.SessionKey))
-		if i > 0 {
-			processor.Prompt.Reset()
-			processor.Prompt.WriteString(processor.RootPrompt.String())
-		}
-		// Initialize client and process request
-		if handleChatRequest(c, session, model, processor, req.Stream) {
-			return // Success, exit the retry loop
+	if !requireSessions(c) {
+		return
+	}
+	// Move oversized history into attachments or a summary
+	applyContextStrategy(c, processor, contextOptionsFor(req, poolSummarizer))
+	index := config.Sr.NextIndex()
+	if !requireProjectSession(c, index, req.Project) || !requireStyle(c, index, req) {
+		return
+	}
+	if req.N > 1 {
+		if !handleChoices(c, poolSessionPicker(index, req.N, req.Project), model, processor, req) {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "Failed to process request after multiple attempts"})
 		}
-
-		// If we're here, the request failed - retry with another session
-		logger.Info("Retrying another session")
+		return
+	}
+	if routeSessions(index, req.Project, model, func(session config.SessionInfo) bool {
+		return handleChatRequest(c, session, model, processor, req)
+	}) {
+		return
 	}
-
-	logger.Error("Failed for all retries")
 	c.JSON(http.StatusInternalServerError, ErrorResponse{
 		Error: "Failed to process request after multiple attempts"})
 }
@@ -120,11 +129,12 @@ func MirrorChatHandler(c *gin.Context) {
 	}
 
 	// Process messages into prompt and extract images
-	processor := utils.NewChatRequestProcessor()
-	processor.ProcessMessages(req.Messages)
-
-	// Get model or use default
 	model := getModelOrDefault(req.Model)
+	processor, err := prepareProcessor(c, req, model)
+	if err != nil {
+		respondPrepareError(c, err)
+		return
+	}
 
 	// Extract session info from auth header
 	session, err := extractSessionFromAuthHeader(c)
@@ -135,8 +145,29 @@ func MirrorChatHandler(c *gin.Context) {
 		return
 	}
 
+	if req.Project != "" && !sessionHasProject(session, req.Project) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: session cannot access project %q", req.Project),
+		})
+		return
+	}
+	if !requireSessionStyle(c, session, req.Style) {
+		return
+	}
+
+	// Move oversized history into attachments or a summary
+	applyContextStrategy(c, processor, contextOptionsFor(req, sessionSummarizer(session)))
+
 	// Process the request with the provided session
-	if !handleChatRequest(c, session, model, processor, req.Stream) {
+	if req.N > 1 {
+		if !handleChoices(c, fixedSessionPicker(session), model, processor, req) {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "Failed to process request",
+			})
+		}
+		return
+	}
+	if !handleChatRequest(c, session, model, processor, req) {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error: "Failed to process request",
 		})
@@ -156,14 +187,144 @@ func parseAndValidateRequest(c *gin.Context) (*model.ChatCompletionRequest, erro
 		return nil, err
 	}
 
+	// 先展开模型别名，-search/-nosearch 后缀只控制网页搜索，不是模型名称的一部分
+	applyModelAlias(&req)
+	applyModelToolSuffix(&req)
+
+	if err := validateRequest(c, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// validateRequest 校验请求参数，并补全请求头中的时区和语言
+func validateRequest(c *gin.Context, req *model.ChatCompletionRequest) error {
 	if len(req.Messages) == 0 {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
 			Error: "No messages provided",
 		})
-		return nil, fmt.Errorf("no messages provided")
+		return fmt.Errorf("no messages provided")
 	}
 
-	return &req, nil
+	if err := utils.ValidateResponseFormat(req.ResponseFormat); err != nil {
+		return err
+	}
+
+	if err := validateThinking(req); err != nil {
+		return err
+	}
+	if err := applyLocale(c, req); err != nil {
+		return err
+	}
+	if req.MaxTokens < 0 || req.MaxCompletionTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative")
+	}
+	if req.N < 0 || req.N > config.ConfigInstance.MaxChoices {
+		return fmt.Errorf("n must be between 1 and %d", config.ConfigInstance.MaxChoices)
+	}
+	if req.N > 1 && utils.IsJSONMode(req.ResponseFormat) {
+		return fmt.Errorf("n > 1 is not supported with response_format %s", req.ResponseFormat.Type)
+	}
+	switch stop := req.Stop.(type) {
+	case nil, string:
+	case []interface{}:
+		if len(stop) > 4 {
+			return fmt.Errorf("at most 4 stop sequences are supported")
+		}
+		for _, s := range stop {
+			if _, ok := s.(string); !ok {
+				return fmt.Errorf("stop must be a string or an array of strings")
+			}
+		}
+	default:
+		return fmt.Errorf("stop must be a string or an array of strings")
+	}
+
+	switch req.ArtifactMode {
+	case "", core.ArtifactModeMarkdown, core.ArtifactModeStructured, core.ArtifactModeToolCalls, core.ArtifactModeResource:
+	default:
+		return fmt.Errorf("unknown artifact_mode %q", req.ArtifactMode)
+	}
+	if req.ArtifactMode == core.ArtifactModeToolCalls && !declaresArtifactTool(req) {
+		return fmt.Errorf("artifact_mode %s requires a function tool named %s", req.ArtifactMode, model.ArtifactFunctionName)
+	}
+	if req.N > 1 && req.ArtifactMode == core.ArtifactModeToolCalls {
+		return fmt.Errorf("n > 1 is not supported with artifact_mode %s", req.ArtifactMode)
+	}
+
+	switch req.ContextStrategy {
+	case "", utils.ContextStrategyFile, utils.ContextStrategyRecent, utils.ContextStrategyNone, utils.ContextStrategyCompact:
+	default:
+		return fmt.Errorf("unknown context_strategy %q", req.ContextStrategy)
+	}
+
+	return nil
+}
+
+// prepareProcessor 使用请求对应的提示词模板渲染消息，并加载和预处理文件
+func prepareProcessor(c *gin.Context, req *model.ChatCompletionRequest, model string) (*utils.ChatRequestProcessor, error) {
+	processor := utils.NewChatRequestProcessor()
+	processor.Template = utils.PromptRendererFor(apiKeyConfig(c).PromptTemplate, model)
+	if err := processor.ProcessMessages(req.Messages); err != nil {
+		return nil, err
+	}
+	if err := processor.PrepareFiles(); err != nil {
+		return nil, err
+	}
+	processor.ProcessImages(config.ConfigInstance.ImageProcessingFor(model))
+	return processor, nil
+}
+
+// respondPrepareError 对无法处理的文件或被拒绝的内容返回 400
+func respondPrepareError(c *gin.Context, err error) {
+	var spoofErr *utils.RoleSpoofingError
+	if errors.As(err, &spoofErr) {
+		logger.Info(fmt.Sprintf("Rejected request: %v", err))
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+	logger.Error(fmt.Sprintf("Failed to prepare file: %v", err))
+	c.JSON(http.StatusBadRequest, ErrorResponse{
+		Error: fmt.Sprintf("Invalid file: %v", err),
+	})
+}
+
+// apiKeyConfig 返回认证中间件识别出的 API 密钥配置，镜像请求时为空
+func apiKeyConfig(c *gin.Context) config.APIKeyConfig {
+	if entry, ok := c.Get("APIKeyConfig"); ok {
+		return entry.(config.APIKeyConfig)
+	}
+	return config.APIKeyConfig{}
+}
+
+// requestOwner 标识保存响应和 artifact 的调用方，只有同一 API 密钥（镜像模式下同一 session）可以读取
+func requestOwner(c *gin.Context) string {
+	if key := apiKeyConfig(c).Key; key != "" {
+		return config.HashSessionKey(key)
+	}
+	return config.HashSessionKey(c.GetHeader("Authorization"))
+}
+
+// contextOptionsFor 合并配置和请求中的超长上下文处理参数
+func contextOptionsFor(req *model.ChatCompletionRequest, summarize utils.Summarizer) utils.ContextOptions {
+	opts := utils.ContextOptions{
+		Strategy:         config.ConfigInstance.ContextStrategy,
+		MaxChars:         config.ConfigInstance.MaxChatHistoryLength,
+		MaxTokens:        config.ConfigInstance.MaxChatHistoryTokens,
+		KeepTurns:        config.ConfigInstance.ContextKeepTurns,
+		AttachmentTokens: config.ConfigInstance.ContextAttachmentTokens,
+		Summarize:        summarize,
+		CompactModel:     config.ConfigInstance.CompactModel,
+	}
+	if req.ContextStrategy != "" {
+		opts.Strategy = req.ContextStrategy
+	}
+	if req.ContextKeepTurns > 0 {
+		opts.KeepTurns = req.ContextKeepTurns
+	}
+	return opts
 }
 
 func getModelOrDefault(model string) string {
@@ -189,59 +350,136 @@ func extractSessionFromAuthHeader(c *gin.Context) (config.SessionInfo, error) {
 	return config.SessionInfo{SessionKey: authInfo, OrgID: ""}, nil
 }
 
-func handleChatRequest(c *gin.Context, session config.SessionInfo, model string, processor *utils.ChatRequestProcessor, stream bool) bool {
-	// Initialize the Claude client
-	claudeClient := core.NewClient(session.SessionKey, config.ConfigInstance.Proxy, model)
+func handleChatRequest(c *gin.Context, session config.SessionInfo, model string, processor *utils.ChatRequestProcessor, req *model.ChatCompletionRequest) bool {
+	stream := req.Stream
+	claudeClient, conversationID, err := startConversation(session, model, processor, conversationOptionsFor(c, req))
+	if err != nil {
+		return respondProjectError(c, err)
+	}
 
-	// Get org ID if not already set
-	if session.OrgID == "" {
-		orgId, err := claudeClient.GetOrgID()
-		if err != nil {
-			logger.Error(fmt.Sprintf("Failed to get org ID: %v", err))
-			return false
+	// JSON mode collects, validates and repairs the answer before responding
+	if utils.IsJSONMode(req.ResponseFormat) {
+		handled := sendJSONMode(c, claudeClient, conversationID, processor.Prompt.String(), req)
+		if !handled || config.ConfigInstance.ChatDelete {
+			go cleanupConversation(claudeClient, conversationID, 3)
 		}
-		session.OrgID = orgId
-		config.ConfigInstance.SetSessionOrgID(session.SessionKey, session.OrgID)
+		return handled
 	}
 
-	claudeClient.SetOrgID(session.OrgID)
+	// Send message, stop sequences and max_tokens are enforced locally on the output
+	claudeClient.SetOutputLimits(core.OutputLimits{Stop: req.StopSequences(), MaxTokens: req.TokenLimit()})
+	claudeClient.SetArtifactMode(artifactModeFor(req))
+	if _, err := claudeClient.SendMessage(conversationID, processor.Prompt.String(), stream, c); err != nil {
+		logger.Error(fmt.Sprintf("Failed to send message: %v", err))
+		go cleanupConversation(claudeClient, conversationID, 3)
+		return false
+	}
 
-	// Upload images if any
-	if len(processor.ImgDataList) > 0 {
-		err := claudeClient.UploadFile(processor.ImgDataList)
+	// Clean up conversation if enabled
+	if config.ConfigInstance.ChatDelete {
+		go cleanupConversation(claudeClient, conversationID, 3)
+	}
+
+	return true
+}
+
+// startConversation 初始化客户端，上传文件和附件并创建对话
+func startConversation(session config.SessionInfo, model string, processor *utils.ChatRequestProcessor, opts conversationOptions) (*core.Client, string, error) {
+	// Initialize the Claude client
+	claudeClient, session, err := newSessionClient(session, model)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get org ID: %v", err))
+		return nil, "", err
+	}
+	claudeClient.SetUpstreamTools(opts.Tools)
+	claudeClient.SetArtifactOwner(opts.Owner)
+	if err := claudeClient.UseStyle(opts.Style); err != nil {
+		logger.Error(fmt.Sprintf("Failed to select style %s: %v", opts.Style, err))
+		return nil, "", err
+	}
+	if opts.Thinking != nil {
+		claudeClient.SetThinking(*opts.Thinking)
+	}
+	claudeClient.SetLocale(firstString(opts.Timezone, session.Timezone), firstString(opts.Locale, session.Locale))
+	if err := claudeClient.UseProject(opts.Project); err != nil {
+		logger.Error(fmt.Sprintf("Failed to use project %s: %v", opts.Project, err))
+		return nil, "", err
+	}
+
+	// Upload images and PDFs if any
+	if len(processor.Files) > 0 {
+		err := claudeClient.UploadFile(processor.Files)
 		if err != nil {
 			logger.Error(fmt.Sprintf("Failed to upload file: %v", err))
-			return false
+			return nil, "", err
 		}
 	}
 
-	// Handle large context if needed
-	if processor.Prompt.Len() > config.ConfigInstance.MaxChatHistoryLength {
-		claudeClient.SetBigContext(processor.Prompt.String())
-		processor.ResetForBigContext()
-		logger.Info(fmt.Sprintf("Prompt length exceeds max limit (%d), using file context", config.ConfigInstance.MaxChatHistoryLength))
+	// Attach text documents and history moved out of the prompt as extracted content
+	for _, doc := range append(processor.ContextDocuments, processor.Documents...) {
+		claudeClient.AddAttachment(doc.FileName, doc.ContentType, string(doc.Data))
 	}
 
 	// Create conversation
 	conversationID, err := claudeClient.CreateConversation()
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to create conversation: %v", err))
-		return false
+		// 项目被拒绝时返回 ErrProjectInvalid，组织保持不变
+		if errors.Is(err, core.ErrOrgInvalid) {
+			config.ConfigInstance.InvalidateSessionOrgID(session.SessionKey, session.OrgID)
+		}
+		return nil, "", err
 	}
+	config.State.Touch(session.SessionKey, session.OrgID, time.Hour)
+	return claudeClient, conversationID, nil
+}
 
-	// Send message
-	if _, err := claudeClient.SendMessage(conversationID, processor.Prompt.String(), stream, c); err != nil {
-		logger.Error(fmt.Sprintf("Failed to send message: %v", err))
-		go cleanupConversation(claudeClient, conversationID, 3)
-		return false
+// newSessionClient 创建客户端，并在 session 未指定组织时从状态文件或上游解析组织
+func newSessionClient(session config.SessionInfo, model string) (*core.Client, config.SessionInfo, error) {
+	claudeClient := core.NewClient(session.SessionKey, config.ConfigInstance.Proxy, model)
+	if session.OrgID == "" {
+		if state, ok := config.State.Resolved(session.SessionKey, session.OrgPolicy()); ok {
+			session.OrgID = state.OrgID
+		} else {
+			orgID, err := resolveSessionOrg(claudeClient, session)
+			if err != nil {
+				return nil, session, err
+			}
+			session.OrgID = orgID
+		}
+		config.ConfigInstance.SetSessionOrgID(session.SessionKey, "", session.OrgID)
 	}
+	claudeClient.SetOrgID(session.OrgID)
+	return claudeClient, session, nil
+}
 
-	// Clean up conversation if enabled
-	if config.ConfigInstance.ChatDelete {
-		go cleanupConversation(claudeClient, conversationID, 3)
+// resolveSessionOrg 从上游解析组织信息并写入状态文件
+func resolveSessionOrg(client *core.Client, session config.SessionInfo) (string, error) {
+	org, err := client.GetOrgID(orgSelectorFor(session))
+	if err != nil {
+		return "", err
+	}
+	email, err := client.GetAccountEmail()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get account email: %v", err))
 	}
+	config.State.Put(session.SessionKey, config.SessionState{
+		OrgID:         org.UUID,
+		Tier:          org.RateLimitTier,
+		Email:         email,
+		Policy:        session.OrgPolicy(),
+		LastValidated: time.Now(),
+	})
+	return org.UUID, nil
+}
 
-	return true
+// orgSelectorFor 根据 session 配置构造组织选择策略
+func orgSelectorFor(session config.SessionInfo) core.OrgSelector {
+	return core.OrgSelector{
+		Name:       session.OrgName,
+		Tier:       session.OrgTier,
+		Capability: session.OrgCapability,
+	}
 }
 
 func cleanupConversation(client *core.Client, conversationID string, retry int) {
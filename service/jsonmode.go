@@ -0,0 +1,56 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"claude2api/model"
+	"claude2api/utils"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sendJSONMode 发送带 JSON 说明的提示词，提取并校验回答，不合格时在同一对话中要求修正，
+// 最多 JSONRepairAttempts 次。返回 false 表示上游请求失败，可以换 session 重试。
+func sendJSONMode(c *gin.Context, client *core.Client, conversationID string, prompt string, req *model.ChatCompletionRequest) bool {
+	message := prompt + utils.JSONInstruction(req.ResponseFormat)
+	attempts := config.ConfigInstance.JSONRepairAttempts
+	var lastErr error
+	for i := 0; i <= attempts; i++ {
+		if i > 0 {
+			if err := client.PrepareFollowUp(conversationID); err != nil {
+				logger.Error(fmt.Sprintf("Failed to continue conversation for JSON repair: %v", err))
+				break
+			}
+			message = utils.JSONRepairInstruction(lastErr)
+		}
+		text, err := client.SendMessageCollect(conversationID, message)
+		if err != nil {
+			var upstreamErr *core.UpstreamError
+			if i == 0 && !errors.As(err, &upstreamErr) {
+				logger.Error(fmt.Sprintf("Failed to send message: %v", err))
+				return false
+			}
+			lastErr = err
+			break
+		}
+		result, err := utils.CheckJSONResponse(req.ResponseFormat, text)
+		if err == nil {
+			if i > 0 {
+				logger.Info(fmt.Sprintf("JSON reply repaired after %d attempts", i))
+			}
+			client.SetRateLimitHeaders(c)
+			model.ReturnOpenAIFullResponse(result, req.Stream, c)
+			return true
+		}
+		logger.Info(fmt.Sprintf("Invalid JSON reply (attempt %d/%d): %v", i+1, attempts+1, err))
+		lastErr = err
+	}
+	c.JSON(http.StatusBadGateway, ErrorResponse{
+		Error: fmt.Sprintf("Model did not return valid JSON: %v", lastErr),
+	})
+	return true
+}
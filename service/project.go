@@ -0,0 +1,241 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"claude2api/utils"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionHasProject 检查 session 的组织中是否有该项目，项目列表会被缓存
+func sessionHasProject(session config.SessionInfo, project string) bool {
+	client, session, err := newSessionClient(session, "")
+	if err != nil {
+		return false
+	}
+	if _, err := client.FindProject(project); err != nil {
+		logger.Info(fmt.Sprintf("Session %s skipped for project %s: %v", maskSessionKey(session.SessionKey), project, err))
+		return false
+	}
+	return true
+}
+
+var errNoSessions = errors.New("no sessions configured")
+
+// nextProjectSession 从 index 开始返回第一个能访问项目的 session 及其下标，未指定项目时直接返回 index 处的 session
+func nextProjectSession(index int, project string) (config.SessionInfo, int, error) {
+	if project == "" {
+		session, err := config.ConfigInstance.GetSessionForModel(index)
+		return session, index, err
+	}
+	n := len(config.ConfigInstance.Sessions)
+	if n == 0 {
+		return config.SessionInfo{}, index, errNoSessions
+	}
+	for i := 0; i < n; i++ {
+		next := (index + i) % n
+		session, err := config.ConfigInstance.GetSessionForModel(next)
+		if err != nil {
+			return session, next, err
+		}
+		if sessionHasProject(session, project) {
+			return session, next, nil
+		}
+	}
+	return config.SessionInfo{}, index, fmt.Errorf("no session can access project %q", project)
+}
+
+// respondProjectError 在上游拒绝请求的项目时返回 400 并停止换 session 重试，其他错误返回 false
+func respondProjectError(c *gin.Context, err error) bool {
+	if !errors.Is(err, core.ErrProjectInvalid) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, ErrorResponse{
+		Error: fmt.Sprintf("Invalid request: %v", err),
+	})
+	return true
+}
+
+// SessionProjects 是管理接口中单个 session 可见的项目
+type SessionProjects struct {
+	Index    int            `json:"index"`
+	Session  string         `json:"session"`
+	OrgID    string         `json:"org_id"`
+	Projects []core.Project `json:"projects"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// AdminProjectsHandler 列出每个 session 的组织中的项目，index 用于知识库接口的 session 参数
+func AdminProjectsHandler(c *gin.Context) {
+	config.ConfigInstance.RwMutx.RLock()
+	sessions := make([]config.SessionInfo, len(config.ConfigInstance.Sessions))
+	copy(sessions, config.ConfigInstance.Sessions)
+	config.ConfigInstance.RwMutx.RUnlock()
+
+	result := make([]SessionProjects, 0, len(sessions))
+	for i, session := range sessions {
+		item := SessionProjects{Index: i, Session: maskSessionKey(session.SessionKey), Projects: []core.Project{}}
+		client, session, err := newSessionClient(session, "")
+		if err == nil {
+			item.OrgID = session.OrgID
+			var projects []core.Project
+			if projects, err = client.ListProjects(); err == nil {
+				item.Projects = projects
+			}
+		}
+		if err != nil {
+			item.Error = err.Error()
+		}
+		result = append(result, item)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": result,
+	})
+}
+
+// projectClient 返回管理请求使用的客户端和项目。?session= 指定 session 下标，
+// 未指定时使用第一个能访问该项目的 session。
+func projectClient(c *gin.Context) (*core.Client, core.Project, bool) {
+	name := c.Param("project")
+	index := 0
+	if value := c.Query("session"); value != "" {
+		var err error
+		if index, err = strconv.Atoi(value); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("invalid session index %q", value)})
+			return nil, core.Project{}, false
+		}
+	}
+	session, next, err := nextProjectSession(index, name)
+	if err != nil || (c.Query("session") != "" && next != index) {
+		if err == nil {
+			err = fmt.Errorf("session %d cannot access project %q", index, name)
+		}
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		return nil, core.Project{}, false
+	}
+	client, _, err := newSessionClient(session, "")
+	if err == nil {
+		var project core.Project
+		if project, err = client.FindProject(name); err == nil {
+			return client, project, true
+		}
+	}
+	c.JSON(http.StatusBadGateway, ErrorResponse{Error: err.Error()})
+	return nil, core.Project{}, false
+}
+
+// AdminProjectDocsHandler 列出项目的知识库文档，不返回文档内容
+func AdminProjectDocsHandler(c *gin.Context) {
+	client, project, ok := projectClient(c)
+	if !ok {
+		return
+	}
+	docs, err := client.ListProjectDocs(project.UUID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, ErrorResponse{Error: err.Error()})
+		return
+	}
+	for i := range docs {
+		docs[i].Content = ""
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"project": project,
+		"data":    docs,
+	})
+}
+
+// AdminUploadProjectDocHandler 上传知识库文档，支持 multipart 的 file 字段或 JSON {"file_name", "content"}
+func AdminUploadProjectDocHandler(c *gin.Context) {
+	maxSize := config.ConfigInstance.MaxFileSize
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
+	var fileName, content string
+	if file, err := c.FormFile("file"); err == nil {
+		if file.Size > maxSize {
+			respondTooLarge(c, maxSize)
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(f, maxSize))
+		f.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		// 知识库只接受文本，Office 和 HTML 文档先提取文本
+		text, err := utils.DocumentText(file.Filename, file.Header.Get("Content-Type"), data)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Invalid file %s: %v", file.Filename, err)})
+			return
+		}
+		fileName, content = file.Filename, text
+	} else if isTooLarge(err) {
+		respondTooLarge(c, maxSize)
+		return
+	} else {
+		var body struct {
+			FileName string `json:"file_name"`
+			Content  string `json:"content"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			if isTooLarge(err) {
+				respondTooLarge(c, maxSize)
+				return
+			}
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Invalid request: %v", err)})
+			return
+		}
+		fileName, content = body.FileName, body.Content
+	}
+	if fileName == "" || content == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "file_name and content are required"})
+		return
+	}
+
+	client, project, ok := projectClient(c)
+	if !ok {
+		return
+	}
+	doc, err := client.UploadProjectDoc(project.UUID, fileName, content)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, ErrorResponse{Error: err.Error()})
+		return
+	}
+	doc.Content = ""
+	c.JSON(http.StatusOK, doc)
+}
+
+// isTooLarge 判断读取请求体时是否超过了 MaxBytesReader 的限制
+func isTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+func respondTooLarge(c *gin.Context, maxSize int64) {
+	c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+		Error: fmt.Sprintf("Document exceeds the size limit of %d bytes", maxSize),
+	})
+}
+
+// AdminDeleteProjectDocHandler 从项目的知识库中删除文档
+func AdminDeleteProjectDocHandler(c *gin.Context) {
+	client, project, ok := projectClient(c)
+	if !ok {
+		return
+	}
+	if err := client.DeleteProjectDoc(project.UUID, c.Param("doc")); err != nil {
+		c.JSON(http.StatusBadGateway, ErrorResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": c.Param("doc")})
+}
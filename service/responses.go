@@ -0,0 +1,375 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"claude2api/model"
+	"claude2api/utils"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResponsesHandler 实现 OpenAI Responses API。输入项转换为 chat 消息后使用与 chat completions
+// 相同的提示词处理和 session 路由，previous_response_id 从保存的响应中恢复之前的对话。
+func ResponsesHandler(c *gin.Context) {
+	useMirror, _ := c.Get("UseMirrorApi")
+	mirror, _ := useMirror.(bool)
+	if mirror && !config.ConfigInstance.EnableMirrorApi {
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error: "Mirror API is not enabled",
+		})
+		return
+	}
+
+	rreq, req, history, err := parseResponsesRequest(c)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == errPreviousResponseNotFound {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+
+	// Process messages into prompt and extract images
+	modelName := getModelOrDefault(req.Model)
+	processor, err := prepareProcessor(c, req, modelName)
+	if err != nil {
+		respondPrepareError(c, err)
+		return
+	}
+	handle := func(session config.SessionInfo) bool {
+		return handleResponsesRequest(c, session, modelName, processor, req, rreq, history)
+	}
+
+	if mirror {
+		session, err := extractSessionFromAuthHeader(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: fmt.Sprintf("Invalid authorization: %v", err),
+			})
+			return
+		}
+		if req.Project != "" && !sessionHasProject(session, req.Project) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: fmt.Sprintf("Invalid request: session cannot access project %q", req.Project),
+			})
+			return
+		}
+		if !requireSessionStyle(c, session, req.Style) {
+			return
+		}
+		applyContextStrategy(c, processor, contextOptionsFor(req, sessionSummarizer(session)))
+		if !handle(session) {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "Failed to process request",
+			})
+		}
+		return
+	}
+
+	if !requireSessions(c) {
+		return
+	}
+	// Move oversized history into attachments or a summary
+	applyContextStrategy(c, processor, contextOptionsFor(req, poolSummarizer))
+	index := config.Sr.NextIndex()
+	if !requireProjectSession(c, index, req.Project) || !requireStyle(c, index, req) {
+		return
+	}
+	if routeSessions(index, req.Project, modelName, handle) {
+		return
+	}
+	c.JSON(http.StatusInternalServerError, ErrorResponse{
+		Error: "Failed to process request after multiple attempts"})
+}
+
+var errPreviousResponseNotFound = fmt.Errorf("previous response not found")
+
+// parseResponsesRequest 解析请求并转换为 chat completions 请求。请求体同时按两种格式解析，
+// 扩展字段因此保持一致。history 是之前的对话和本次输入，不含 instructions。
+func parseResponsesRequest(c *gin.Context) (*model.ResponsesRequest, *model.ChatCompletionRequest, []map[string]interface{}, error) {
+	body, err := c.GetRawData()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var rreq model.ResponsesRequest
+	if err := json.Unmarshal(body, &rreq); err != nil {
+		return nil, nil, nil, err
+	}
+	var req model.ChatCompletionRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, nil, nil, err
+	}
+	if req.N > 1 {
+		return nil, nil, nil, fmt.Errorf("n is not supported")
+	}
+
+	var history []map[string]interface{}
+	if rreq.PreviousResponseID != "" {
+		previous, ok := core.Responses.Get(rreq.PreviousResponseID, requestOwner(c))
+		if !ok {
+			return nil, nil, nil, errPreviousResponseNotFound
+		}
+		history = append(history, previous.Messages...)
+	}
+	input, err := responseInputMessages(rreq.Input)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(input) == 0 {
+		return nil, nil, nil, fmt.Errorf("no input provided")
+	}
+	history = append(history, input...)
+
+	// instructions 只作用于本次请求，不随 previous_response_id 延续
+	req.Messages = history
+	if rreq.Instructions != "" {
+		req.Messages = append([]map[string]interface{}{{"role": "system", "content": rreq.Instructions}}, history...)
+	}
+	req.Stream = rreq.Stream
+	req.MaxCompletionTokens = rreq.MaxOutputTokens
+	if rreq.Reasoning != nil && rreq.Reasoning.Effort != "" && req.ReasoningEffort == "" {
+		req.ReasoningEffort = rreq.Reasoning.Effort
+	}
+
+	// 先展开模型别名，-search/-nosearch 后缀只控制网页搜索，不是模型名称的一部分
+	applyModelAlias(&req)
+	applyModelToolSuffix(&req)
+	if err := validateRequest(c, &req); err != nil {
+		return nil, nil, nil, err
+	}
+	if utils.IsJSONMode(req.ResponseFormat) {
+		return nil, nil, nil, fmt.Errorf("response_format is not supported, use chat completions for JSON mode")
+	}
+	return &rreq, &req, history, nil
+}
+
+// responseInputMessages 把 Responses API 的输入转换为 chat 消息
+func responseInputMessages(input interface{}) ([]map[string]interface{}, error) {
+	switch v := input.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []map[string]interface{}{{"role": "user", "content": v}}, nil
+	case []interface{}:
+		var messages []map[string]interface{}
+		for i, raw := range v {
+			item, ok := raw.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("input item %d is not an object", i)
+			}
+			itemType, _ := item["type"].(string)
+			switch itemType {
+			case "", "message":
+				role, _ := item["role"].(string)
+				if role == "" {
+					return nil, fmt.Errorf("input item %d has no role", i)
+				}
+				content, err := responseContentParts(item["content"])
+				if err != nil {
+					return nil, fmt.Errorf("input item %d: %w", i, err)
+				}
+				messages = append(messages, map[string]interface{}{"role": role, "content": content})
+			case "function_call":
+				messages = append(messages, map[string]interface{}{
+					"role": "assistant",
+					"tool_calls": []interface{}{map[string]interface{}{
+						"id":   item["call_id"],
+						"type": "function",
+						"function": map[string]interface{}{
+							"name":      item["name"],
+							"arguments": item["arguments"],
+						},
+					}},
+				})
+			case "function_call_output":
+				output := item["output"]
+				if _, ok := output.(string); !ok {
+					data, _ := json.Marshal(output)
+					output = string(data)
+				}
+				messages = append(messages, map[string]interface{}{
+					"role":         "tool",
+					"tool_call_id": item["call_id"],
+					"content":      output,
+				})
+			case "reasoning":
+				// 之前的思考内容不再发送
+			default:
+				return nil, fmt.Errorf("unsupported input item type %q", itemType)
+			}
+		}
+		return messages, nil
+	default:
+		return nil, fmt.Errorf("input must be a string or an array of items")
+	}
+}
+
+// responseContentParts 把输入项的内容转换为 chat 消息的内容
+func responseContentParts(content interface{}) (interface{}, error) {
+	parts, ok := content.([]interface{})
+	if !ok {
+		if _, ok := content.(string); ok {
+			return content, nil
+		}
+		return nil, fmt.Errorf("content must be a string or an array of parts")
+	}
+	result := make([]interface{}, 0, len(parts))
+	for _, raw := range parts {
+		part, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch partType, _ := part["type"].(string); partType {
+		case "input_text", "output_text", "text":
+			result = append(result, map[string]interface{}{"type": "text", "text": part["text"]})
+		case "input_image":
+			url, _ := part["image_url"].(string)
+			if url == "" {
+				return nil, fmt.Errorf("input_image requires image_url")
+			}
+			result = append(result, map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": url}})
+		case "input_file":
+			result = append(result, part)
+		case "refusal":
+		default:
+			return nil, fmt.Errorf("unsupported content type %q", partType)
+		}
+	}
+	return result, nil
+}
+
+// handleResponsesRequest 在 session 中生成响应，输出开始前失败时返回 false 以便换 session 重试
+func handleResponsesRequest(c *gin.Context, session config.SessionInfo, modelName string, processor *utils.ChatRequestProcessor, req *model.ChatCompletionRequest, rreq *model.ResponsesRequest, history []map[string]interface{}) bool {
+	claudeClient, conversationID, err := startConversation(session, modelName, processor, conversationOptionsFor(c, req))
+	if err != nil {
+		return respondProjectError(c, err)
+	}
+
+	// artifact 以 markdown 代码块返回，思考内容作为 reasoning 输出项
+	claudeClient.SetOutputLimits(core.OutputLimits{Stop: req.StopSequences(), MaxTokens: req.TokenLimit()})
+	claudeClient.SetArtifactMode(core.ArtifactModeMarkdown)
+	response := model.NewResponse(modelName, rreq)
+	out := model.NewResponseStream(response, req.Stream, c)
+	var reasoning string
+	claudeClient.OnThinking(func(text string) {
+		reasoning += text
+		out.Reasoning(text)
+	})
+	claudeClient.OnCitation(out.Citation)
+	claudeClient.SetRateLimitHeaders(c)
+	prompt := processor.Prompt.String()
+	output, err := claudeClient.SendMessageChoice(conversationID, prompt, c.Request.Context().Done(), out.Text)
+	if err != nil || config.ConfigInstance.ChatDelete {
+		go cleanupConversation(claudeClient, conversationID, 3)
+	}
+	if err != nil {
+		if c.Request.Context().Err() != nil {
+			logger.Info("Client closed connection")
+			return true
+		}
+		logger.Error(fmt.Sprintf("Failed to send message: %v", err))
+		if !out.Started() {
+			return false
+		}
+		out.Fail(err.Error())
+		return true
+	}
+
+	text := response.OutputText()
+	usage := model.ResponseUsage{
+		InputTokens:  utils.EstimateTokens(prompt),
+		OutputTokens: utils.EstimateTokens(reasoning + text),
+	}
+	usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+	claudeClient.SetRateLimitHeaders(c)
+	out.Finish(output.FinishReason, usage)
+
+	if response.Store {
+		messages := append(storableMessages(history), map[string]interface{}{"role": "assistant", "content": text})
+		if !core.Responses.Put(core.StoredResponse{Response: *response, Messages: messages, Owner: requestOwner(c)}) {
+			logger.Info(fmt.Sprintf("Response %s is too large to store", response.ID))
+		}
+	}
+	return true
+}
+
+// storableMessages 返回去掉文件内容的对话副本。base64 图片和文件只在本次请求中发送，
+// 保存时替换为说明文字，延续的对话中模型只能看到之前回复里对它们的描述。
+func storableMessages(history []map[string]interface{}) []map[string]interface{} {
+	messages := make([]map[string]interface{}, 0, len(history)+1)
+	for _, message := range history {
+		parts, ok := message["content"].([]interface{})
+		if !ok {
+			messages = append(messages, message)
+			continue
+		}
+		stripped := make([]interface{}, 0, len(parts))
+		for _, raw := range parts {
+			stripped = append(stripped, storablePart(raw))
+		}
+		copied := make(map[string]interface{}, len(message))
+		for k, v := range message {
+			copied[k] = v
+		}
+		copied["content"] = stripped
+		messages = append(messages, copied)
+	}
+	return messages
+}
+
+// storablePart 把内联的图片或文件内容替换为文本，URL 引用保持不变
+func storablePart(raw interface{}) interface{} {
+	part, ok := raw.(map[string]interface{})
+	if !ok {
+		return raw
+	}
+	switch part["type"] {
+	case "image_url":
+		image, _ := part["image_url"].(map[string]interface{})
+		if url, _ := image["url"].(string); strings.HasPrefix(url, "data:") {
+			return map[string]interface{}{"type": "text", "text": "[image omitted]"}
+		}
+	case "input_file":
+		if _, ok := part["file_data"]; ok {
+			text := "[file omitted]"
+			if name, _ := part["filename"].(string); name != "" {
+				text = fmt.Sprintf("[file %s omitted]", name)
+			}
+			return map[string]interface{}{"type": "text", "text": text}
+		}
+	}
+	return part
+}
+
+// GetResponseHandler 返回保存的响应
+func GetResponseHandler(c *gin.Context) {
+	stored, ok := core.Responses.Get(c.Param("id"), requestOwner(c))
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Response not found or expired",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, stored.Response)
+}
+
+// DeleteResponseHandler 删除保存的响应
+func DeleteResponseHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !core.Responses.Delete(id, requestOwner(c)) {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Response not found or expired",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "object": "response.deleted", "deleted": true})
+}
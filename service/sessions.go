@@ -0,0 +1,171 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"claude2api/model"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// nextSession 在 nextProjectSession 按轮询顺序给出的 session 中按额度选择，返回 session 及其下标。
+// 返回第一个有余量的 session；都接近上限或已超限时返回其中额度状态最好的一个。
+func nextSession(index int, project string) (config.SessionInfo, int, error) {
+	n := len(config.ConfigInstance.Sessions)
+	if n == 0 {
+		return config.SessionInfo{}, index, errNoSessions
+	}
+	index %= n
+	var best config.SessionInfo
+	bestIndex, bestRank := -1, 0
+	for i := 0; i < n; i++ {
+		session, next, err := nextProjectSession((index+i)%n, project)
+		if err != nil {
+			return session, next, err
+		}
+		// nextProjectSession 跳过了无法访问项目的 session，绕回起点后结束
+		offset := (next - index + n) % n
+		if offset < i {
+			break
+		}
+		i = offset
+		rank := config.Usage.Rank(session)
+		if rank == config.UsageRankAvailable {
+			return session, next, nil
+		}
+		if bestIndex < 0 || rank < bestRank {
+			best, bestIndex, bestRank = session, next, rank
+		}
+	}
+	return best, bestIndex, nil
+}
+
+// requireSessions 检查 session 池是否为空，为空时返回 503
+func requireSessions(c *gin.Context) bool {
+	if len(config.ConfigInstance.Sessions) > 0 {
+		return true
+	}
+	c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+		Error: errNoSessions.Error(),
+	})
+	return false
+}
+
+// requireProjectSession 检查是否有 session 能访问请求的项目，没有时返回 400
+func requireProjectSession(c *gin.Context, index int, project string) bool {
+	if project == "" {
+		return true
+	}
+	if _, _, err := nextSession(index+1, project); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return false
+	}
+	return true
+}
+
+// requireStyle 检查请求的风格是否存在，没有时返回 400。内置和配置中的风格无需 session，
+// 其他名称在将要使用的第一个 session 的账号风格中查找
+func requireStyle(c *gin.Context, index int, req *model.ChatCompletionRequest) bool {
+	if req.Style == "" || core.IsKnownStyle(req.Style) {
+		return true
+	}
+	session, _, err := nextSession(index+1, req.Project)
+	if err != nil {
+		// 没有可用 session 时由路由返回错误
+		return true
+	}
+	return requireSessionStyle(c, session, req.Style)
+}
+
+// requireSessionStyle 检查 session 的账号中是否有请求的风格，没有时返回 400。
+// 查询风格列表失败时不拒绝请求，对话开始时会再次选择风格。
+func requireSessionStyle(c *gin.Context, session config.SessionInfo, style string) bool {
+	if style == "" || core.IsKnownStyle(style) {
+		return true
+	}
+	client, _, err := newSessionClient(session, "")
+	if err != nil {
+		return true
+	}
+	if _, err := client.FindStyle(style); errors.Is(err, core.ErrUnknownStyle) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return false
+	}
+	return true
+}
+
+// routeSessions 从 index 之后按 session 池的顺序调用 handle，失败时换 session 重试，最多 RetryCount 次。
+// 全部失败时返回 false。
+func routeSessions(index int, project string, modelName string, handle func(config.SessionInfo) bool) bool {
+	if len(config.ConfigInstance.Sessions) == 0 {
+		logger.Error(fmt.Sprintf("Failed to get session for model %s: %v", modelName, errNoSessions))
+		return false
+	}
+	for i := 0; i < config.ConfigInstance.RetryCount; i++ {
+		index = (index + 1) % len(config.ConfigInstance.Sessions)
+		session, next, err := nextSession(index, project)
+		index = next
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to get session for model %s: %v", modelName, err))
+			logger.Info("Retrying another session")
+			continue
+		}
+
+		logger.Info(fmt.Sprintf("Using session for model %s: %s", modelName, session.SessionKey))
+		if handle(session) {
+			return true
+		}
+
+		// If we're here, the request failed - retry with another session
+		logger.Info("Retrying another session")
+	}
+	logger.Error("Failed for all retries")
+	return false
+}
+
+// SessionStatus 是管理接口中单个 session 的状态
+type SessionStatus struct {
+	Index         int                `json:"index"`
+	Session       string             `json:"session"`
+	OrgID         string             `json:"org_id"`
+	Tier          string             `json:"tier,omitempty"`
+	Email         string             `json:"email,omitempty"`
+	LastValidated *time.Time         `json:"last_validated,omitempty"`
+	Usage         *config.UsageLimit `json:"usage"`
+	Available     bool               `json:"available"` // 额度没有接近上限，选择时优先
+}
+
+// AdminSessionsHandler 列出 session 池中每个 session 的组织和最近一次上报的额度
+func AdminSessionsHandler(c *gin.Context) {
+	config.ConfigInstance.RwMutx.RLock()
+	sessions := make([]config.SessionInfo, len(config.ConfigInstance.Sessions))
+	copy(sessions, config.ConfigInstance.Sessions)
+	config.ConfigInstance.RwMutx.RUnlock()
+
+	result := make([]SessionStatus, 0, len(sessions))
+	for i, session := range sessions {
+		item := SessionStatus{Index: i, Session: maskSessionKey(session.SessionKey), OrgID: session.OrgID, Available: true}
+		if state, ok := config.State.Get(session.SessionKey, session.OrgID); ok {
+			item.Tier = state.Tier
+			item.Email = state.Email
+			item.LastValidated = &state.LastValidated
+		}
+		if limit, ok := config.Usage.Get(session.SessionKey, session.OrgID); ok {
+			item.Usage = &limit
+			item.Available = limit.Rank() == config.UsageRankAvailable
+		}
+		result = append(result, item)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": result,
+	})
+}
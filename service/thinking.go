@@ -0,0 +1,66 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"claude2api/model"
+	"fmt"
+	"strings"
+)
+
+// effortRank 按强度排列 reasoning_effort，none 表示关闭思考
+var effortRank = map[string]int{"none": 0, "minimal": 1, "low": 2, "medium": 3, "high": 4}
+
+// thinkingModelPrefixes 是支持扩展思考的模型
+var thinkingModelPrefixes = []string{"claude-3-7-sonnet", "claude-sonnet-4", "claude-opus-4"}
+
+// supportsThinking 判断模型是否支持扩展思考
+func supportsThinking(modelName string) bool {
+	modelName = strings.TrimSuffix(modelName, "-think")
+	for _, prefix := range thinkingModelPrefixes {
+		if strings.HasPrefix(modelName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateThinking 检查 reasoning_effort 和 thinking 参数
+func validateThinking(req *model.ChatCompletionRequest) error {
+	if _, ok := effortRank[req.ReasoningEffort]; req.ReasoningEffort != "" && !ok {
+		return fmt.Errorf("unknown reasoning_effort %q", req.ReasoningEffort)
+	}
+	if req.Thinking == nil {
+		return nil
+	}
+	switch req.Thinking.Type {
+	case "enabled":
+		if req.Thinking.BudgetTokens != 0 && req.Thinking.BudgetTokens < 1024 {
+			return fmt.Errorf("thinking.budget_tokens must be at least 1024")
+		}
+	case "disabled":
+	default:
+		return fmt.Errorf("thinking.type must be enabled or disabled")
+	}
+	return nil
+}
+
+// thinkingFor 把 thinking 或 reasoning_effort 映射为扩展思考的开关，都未设置时返回 nil，
+// 由 -think 后缀决定。claude.ai 没有思考预算，budget_tokens 只表示开启；达到
+// ThinkingMinEffort 的 reasoning_effort 开启思考。
+func thinkingFor(req *model.ChatCompletionRequest, modelName string) *bool {
+	var enabled bool
+	switch {
+	case req.Thinking != nil:
+		enabled = req.Thinking.Type == "enabled"
+	case req.ReasoningEffort != "":
+		enabled = effortRank[req.ReasoningEffort] >= effortRank[config.ConfigInstance.ThinkingMinEffort]
+	default:
+		return nil
+	}
+	if enabled && !supportsThinking(modelName) {
+		logger.Info(fmt.Sprintf("Model %s does not support extended thinking, ignoring it", modelName))
+		enabled = false
+	}
+	return &enabled
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"claude2api/config"
+	"claude2api/core"
+	"claude2api/logger"
+	"claude2api/model"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// conversationOptions 是创建对话时应用的请求设置
+type conversationOptions struct {
+	Tools    core.UpstreamTools
+	Style    string
+	Thinking *bool  // nil 时由 -think 后缀决定
+	Timezone string // 为空时使用 session 或全局时区
+	Locale   string // 为空时使用 session 或全局 Accept-Language
+	Project  string // claude.ai 项目的 uuid 或名称
+	Owner    string // 保存的 artifact 的所有者
+}
+
+func conversationOptionsFor(c *gin.Context, req *model.ChatCompletionRequest) conversationOptions {
+	return conversationOptions{
+		Tools:    upstreamToolsFor(c, req),
+		Style:    req.Style,
+		Thinking: thinkingFor(req, getModelOrDefault(req.Model)),
+		Timezone: req.Timezone,
+		Locale:   req.Locale,
+		Project:  req.Project,
+		Owner:    requestOwner(c),
+	}
+}
+
+// applyModelAlias 把模型别名替换为实际模型，请求未设置的参数使用别名的设置
+func applyModelAlias(req *model.ChatCompletionRequest) {
+	alias, ok := config.ConfigInstance.ModelAlias(req.Model)
+	if !ok {
+		return
+	}
+	req.Model = alias.Model
+	if req.Style == "" {
+		req.Style = alias.Style
+	}
+	if req.ReasoningEffort == "" && req.Thinking == nil {
+		req.ReasoningEffort = alias.ReasoningEffort
+	}
+	if req.Project == "" {
+		req.Project = alias.Project
+	}
+}
+
+// applyModelToolSuffix 去掉模型名中的 -search/-nosearch 后缀，请求未设置 web_search 时按后缀设置。
+// 后缀可以与 -think 以任意顺序组合。
+func applyModelToolSuffix(req *model.ChatCompletionRequest) {
+	name := req.Model
+	think := false
+	var search *bool
+	for {
+		switch {
+		case strings.HasSuffix(name, "-think"):
+			name = strings.TrimSuffix(name, "-think")
+			think = true
+		case strings.HasSuffix(name, "-nosearch"):
+			name = strings.TrimSuffix(name, "-nosearch")
+			search = boolPtr(false)
+		case strings.HasSuffix(name, "-search"):
+			name = strings.TrimSuffix(name, "-search")
+			search = boolPtr(true)
+		default:
+			if search == nil {
+				return
+			}
+			if think {
+				name += "-think"
+			}
+			req.Model = name
+			if req.WebSearch == nil {
+				req.WebSearch = search
+			}
+			return
+		}
+	}
+}
+
+// upstreamToolsFor 返回请求启用的 claude.ai 工具：请求参数优先，其次是 API 密钥的默认值，默认全部启用
+func upstreamToolsFor(c *gin.Context, req *model.ChatCompletionRequest) core.UpstreamTools {
+	defaults := apiKeyConfig(c).Tools
+	return core.UpstreamTools{
+		WebSearch: firstBool(req.WebSearch, defaults.WebSearch),
+		Artifacts: firstBool(req.Artifacts, defaults.Artifacts),
+		REPL:      firstBool(req.REPL, defaults.REPL),
+	}
+}
+
+// firstBool 返回第一个已设置的值，都未设置时为 true
+func firstBool(values ...*bool) bool {
+	for _, v := range values {
+		if v != nil {
+			return *v
+		}
+	}
+	return true
+}
+
+// applyLocale 校验请求体中的时区和语言，未设置时从 X-Timezone/Accept-Language 请求头补全。
+// 请求头常由浏览器或 SDK 自动添加，无效的值只记录日志并忽略，不拒绝请求。
+func applyLocale(c *gin.Context, req *model.ChatCompletionRequest) error {
+	if req.Timezone != "" {
+		if err := config.ValidateTimezone(req.Timezone); err != nil {
+			return err
+		}
+	} else if tz := strings.TrimSpace(c.GetHeader("X-Timezone")); tz != "" {
+		if err := config.ValidateTimezone(tz); err != nil {
+			logger.Info(fmt.Sprintf("Ignoring X-Timezone header: %v", err))
+		} else {
+			req.Timezone = tz
+		}
+	}
+	if req.Locale != "" {
+		if err := config.ValidateAcceptLanguage(req.Locale); err != nil {
+			return err
+		}
+	} else if locale := strings.TrimSpace(c.GetHeader("Accept-Language")); locale != "" {
+		if err := config.ValidateAcceptLanguage(locale); err != nil {
+			logger.Info(fmt.Sprintf("Ignoring Accept-Language header: %v", err))
+		} else {
+			req.Locale = locale
+		}
+	}
+	return nil
+}
+
+// firstString 返回第一个非空的值
+func firstString(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"claude2api/logger"
+	"claude2api/model"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Context strategies applied when the prompt exceeds the history limit
+const (
+	ContextStrategyFile   = "file"   // Move the whole transcript into attachments
+	ContextStrategyRecent = "recent" // Keep system prompts and the last turns inline, move older turns
+	ContextStrategyNone   = "none"   // Always send the prompt inline
+	// Summarize older turns with a side conversation, keep system prompts and the last turns inline
+	ContextStrategyCompact = "compact"
+)
+
+// ContextOptions controls how oversized histories are handled
+type ContextOptions struct {
+	Strategy         string
+	MaxChars         int // Character threshold, used when MaxTokens is 0; 0 moves every history
+	MaxTokens        int // Estimated token threshold
+	KeepTurns        int // Turns kept inline by the recent strategy
+	AttachmentTokens int // Estimated tokens per attachment before splitting
+	// Used by the compact strategy
+	Summarize    Summarizer
+	CompactModel string // Part of the summary cache key
+}
+
+// EstimateTokens roughly estimates the token count: CJK characters count as one token each,
+// other text as one token per four characters
+func EstimateTokens(s string) int {
+	cjk := 0
+	other := 0
+	for _, r := range s {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// exceeds reports whether the text is over the configured threshold
+func (o ContextOptions) exceeds(s string) bool {
+	if o.MaxTokens > 0 {
+		return EstimateTokens(s) > o.MaxTokens
+	}
+	return len(s) > o.MaxChars
+}
+
+// ApplyContextStrategy rewrites the prompt when it exceeds the threshold and stores the moved
+// history in ContextDocuments. It returns the strategy that was applied.
+func (p *ChatRequestProcessor) ApplyContextStrategy(opts ContextOptions) string {
+	prompt := p.Prompt.String()
+	if opts.Strategy == ContextStrategyNone || !opts.exceeds(prompt) {
+		logger.Debug(fmt.Sprintf("Context strategy: %s (estimated tokens=%d)", ContextStrategyNone, EstimateTokens(prompt)))
+		return ContextStrategyNone
+	}
+	if opts.Strategy == ContextStrategyCompact {
+		if p.applyCompactStrategy(opts) {
+			return ContextStrategyCompact
+		}
+		logger.Info("Compaction not applied, falling back to recent strategy")
+	}
+	if (opts.Strategy == ContextStrategyRecent || opts.Strategy == ContextStrategyCompact) && p.applyRecentStrategy(opts) {
+		return ContextStrategyRecent
+	}
+
+	p.ContextDocuments = splitAttachments("context", prompt, opts.AttachmentTokens)
+	p.Prompt.Reset()
+	p.Prompt.WriteString(p.header)
+	p.Prompt.WriteString(p.Template.BigContext(fileNames(p.ContextDocuments)))
+	logger.Info(fmt.Sprintf("Context strategy: %s (estimated tokens=%d, attachments=%d)",
+		ContextStrategyFile, EstimateTokens(prompt), len(p.ContextDocuments)))
+	return ContextStrategyFile
+}
+
+// applyRecentStrategy keeps system turns and the last KeepTurns non-system turns inline and moves the
+// other turns into history attachments. It returns false when nothing can be moved or the
+// inline part is still over the threshold.
+func (p *ChatRequestProcessor) applyRecentStrategy(opts ContextOptions) bool {
+	system, older, recent := p.splitTurns(opts.KeepTurns)
+	if len(older) == 0 {
+		return false
+	}
+
+	var history strings.Builder
+	for _, turn := range older {
+		history.WriteString(turn.Text)
+	}
+	documents := splitAttachments("history", history.String(), opts.AttachmentTokens)
+
+	var prompt strings.Builder
+	prompt.WriteString(p.header)
+	for _, turn := range system {
+		prompt.WriteString(turn.Text)
+	}
+	prompt.WriteString(p.Template.RecentContext(fileNames(documents)))
+	for _, turn := range recent {
+		prompt.WriteString(turn.Text)
+	}
+	if opts.exceeds(prompt.String()) {
+		logger.Info("Recent turns still exceed the context limit, falling back to file strategy")
+		return false
+	}
+
+	p.ContextDocuments = documents
+	p.Prompt.Reset()
+	p.Prompt.WriteString(prompt.String())
+	logger.Info(fmt.Sprintf("Context strategy: %s (inline turns=%d, system turns=%d, moved turns=%d, attachments=%d, estimated tokens=%d)",
+		ContextStrategyRecent, len(recent), len(system), len(older), len(documents), EstimateTokens(history.String())))
+	return true
+}
+
+// splitTurns separates the older turns from the last keep non-system turns. System turns
+// before the recent turns always stay inline and are returned in system; system turns among the
+// recent turns keep their position in recent.
+func (p *ChatRequestProcessor) splitTurns(keep int) (system, older, recent []Turn) {
+	if keep <= 0 {
+		keep = 1
+	}
+	// cut is the index of the first of the last keep non-system turns
+	cut := 0
+	for i := len(p.Turns) - 1; i >= 0; i-- {
+		if p.Turns[i].Role == "system" {
+			continue
+		}
+		cut = i
+		if keep--; keep == 0 {
+			break
+		}
+	}
+	for i, turn := range p.Turns {
+		switch {
+		case i >= cut:
+			recent = append(recent, turn)
+		case turn.Role == "system":
+			system = append(system, turn)
+		default:
+			older = append(older, turn)
+		}
+	}
+	return system, older, recent
+}
+
+func fileNames(files []model.FileInput) []string {
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = file.FileName
+	}
+	return names
+}
+
+// splitAttachments splits text into attachments of at most maxTokens estimated tokens,
+// breaking at line boundaries. A single attachment is named <base>.txt, several <base>_N.txt.
+func splitAttachments(base, text string, maxTokens int) []model.FileInput {
+	var parts []string
+	if maxTokens <= 0 || EstimateTokens(text) <= maxTokens {
+		parts = []string{text}
+	} else {
+		var current strings.Builder
+		currentTokens := 0
+		for _, line := range strings.SplitAfter(text, "\n") {
+			lineTokens := EstimateTokens(line)
+			if currentTokens > 0 && currentTokens+lineTokens > maxTokens {
+				parts = append(parts, current.String())
+				current.Reset()
+				currentTokens = 0
+			}
+			current.WriteString(line)
+			currentTokens += lineTokens
+		}
+		if current.Len() > 0 {
+			parts = append(parts, current.String())
+		}
+	}
+
+	documents := make([]model.FileInput, len(parts))
+	for i, part := range parts {
+		name := base + ".txt"
+		if len(parts) > 1 {
+			name = fmt.Sprintf("%s_%d.txt", base, i+1)
+		}
+		documents[i] = model.FileInput{FileName: name, ContentType: "text/plain", Data: []byte(part)}
+	}
+	return documents
+}
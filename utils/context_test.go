@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// turns builds turns from "role:text" specs, using the text as the turn label in results
+func turns(specs ...string) []Turn {
+	result := make([]Turn, len(specs))
+	for i, spec := range specs {
+		role, text, _ := strings.Cut(spec, ":")
+		result[i] = Turn{Role: role, Text: text}
+	}
+	return result
+}
+
+func turnTexts(turns []Turn) string {
+	texts := make([]string, len(turns))
+	for i, turn := range turns {
+		texts[i] = turn.Text
+	}
+	return strings.Join(texts, " ")
+}
+
+func TestSplitTurns(t *testing.T) {
+	tests := []struct {
+		name                  string
+		turns                 []Turn
+		keep                  int
+		system, older, recent string
+	}{
+		{
+			name:  "leading system hoisted",
+			turns: turns("system:s1", "user:u1", "assistant:a1", "user:u2", "assistant:a2", "user:u3"),
+			keep:  2, system: "s1", older: "u1 a1 u2", recent: "a2 u3",
+		},
+		{
+			name:  "trailing system does not count",
+			turns: turns("user:u1", "assistant:a1", "user:u2", "system:s1"),
+			keep:  2, system: "", older: "u1", recent: "a1 u2 s1",
+		},
+		{
+			name:  "late system keeps its position",
+			turns: turns("system:s1", "user:u1", "assistant:a1", "system:s2", "user:u2"),
+			keep:  2, system: "s1", older: "u1", recent: "a1 s2 u2",
+		},
+		{
+			name:  "system between older turns hoisted",
+			turns: turns("user:u1", "system:s1", "assistant:a1", "user:u2"),
+			keep:  1, system: "s1", older: "u1 a1", recent: "u2",
+		},
+		{
+			name:  "system at the cut stays before the recent turns",
+			turns: turns("user:u1", "assistant:a1", "system:s1", "user:u2"),
+			keep:  1, system: "s1", older: "u1 a1", recent: "u2",
+		},
+		{
+			name:  "fewer turns than keep",
+			turns: turns("system:s1", "user:u1", "assistant:a1"),
+			keep:  4, system: "s1", older: "", recent: "u1 a1",
+		},
+		{
+			name:  "keep defaults to one",
+			turns: turns("user:u1", "assistant:a1"),
+			keep:  0, system: "", older: "u1", recent: "a1",
+		},
+		{
+			name:  "only system turns",
+			turns: turns("system:s1", "system:s2"),
+			keep:  1, system: "", older: "", recent: "s1 s2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ChatRequestProcessor{Turns: tt.turns}
+			system, older, recent := p.splitTurns(tt.keep)
+			if got := turnTexts(system); got != tt.system {
+				t.Errorf("system = %q, want %q", got, tt.system)
+			}
+			if got := turnTexts(older); got != tt.older {
+				t.Errorf("older = %q, want %q", got, tt.older)
+			}
+			if got := turnTexts(recent); got != tt.recent {
+				t.Errorf("recent = %q, want %q", got, tt.recent)
+			}
+		})
+	}
+}
@@ -0,0 +1,588 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	docxType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	xlsxType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	pptxType = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+)
+
+// maxExtractedPartSize limits how much of a single zip entry is read, guarding against zip bombs
+const maxExtractedPartSize = 64 * 1024 * 1024
+
+// maxExtractedText caps the text returned for a single document
+const maxExtractedText = 2 * 1024 * 1024
+
+const (
+	// maxSheetColumns is the number of columns a worksheet can have (A to XFD)
+	maxSheetColumns = 16384
+	// maxSheetCells bounds the padded cells kept for one worksheet
+	maxSheetCells = 1 << 20
+)
+
+// extractors convert documents that claude.ai cannot take from this proxy into plain text
+var extractors = map[string]func([]byte) (string, error){
+	docxType:                extractDocx,
+	xlsxType:                extractXlsx,
+	pptxType:                extractPptx,
+	"text/html":             extractHTML,
+	"application/xhtml+xml": extractHTML,
+}
+
+// extractText returns the text content of an Office Open XML or HTML document
+func extractText(contentType string, data []byte) (string, bool, error) {
+	extractor, ok := extractors[contentType]
+	if !ok {
+		return "", false, nil
+	}
+	text, err := extractor(data)
+	if err != nil {
+		return "", true, fmt.Errorf("failed to extract text: %w", err)
+	}
+	return truncateExtracted(strings.TrimSpace(text)), true, nil
+}
+
+// truncateExtracted cuts text longer than maxExtractedText at a rune boundary
+func truncateExtracted(text string) string {
+	if len(text) <= maxExtractedText {
+		return text
+	}
+	cut := maxExtractedText
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "\n\n[Document truncated]"
+}
+
+// tableWriter renders table rows as markdown, adding the header separator after the first row
+type tableWriter struct {
+	out  *strings.Builder
+	rows int
+	row  []string
+	cell strings.Builder
+}
+
+func (t *tableWriter) endCell() {
+	text := strings.Join(strings.Fields(t.cell.String()), " ")
+	t.row = append(t.row, strings.ReplaceAll(text, "|", "\\|"))
+	t.cell.Reset()
+}
+
+func (t *tableWriter) endRow() {
+	if len(t.row) == 0 {
+		return
+	}
+	t.out.WriteString("| " + strings.Join(t.row, " | ") + " |\n")
+	if t.rows == 0 {
+		t.out.WriteString(strings.Repeat("| --- ", len(t.row)) + "|\n")
+	}
+	t.rows++
+	t.row = nil
+}
+
+func (t *tableWriter) end() {
+	t.out.WriteString("\n")
+	t.rows = 0
+	t.row = nil
+}
+
+// extractDocx reads paragraphs and tables from word/document.xml
+func extractDocx(data []byte) (string, error) {
+	files, err := openZip(data)
+	if err != nil {
+		return "", err
+	}
+	doc, err := readZipFile(files, "word/document.xml")
+	if err != nil {
+		return "", err
+	}
+	return extractOOXMLText(doc, "w")
+}
+
+// extractPptx reads the text of every slide in order
+func extractPptx(data []byte) (string, error) {
+	files, err := openZip(data)
+	if err != nil {
+		return "", err
+	}
+	var slides []int
+	for name := range files {
+		if strings.HasPrefix(name, "ppt/slides/slide") && strings.HasSuffix(name, ".xml") {
+			n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "ppt/slides/slide"), ".xml"))
+			if err == nil {
+				slides = append(slides, n)
+			}
+		}
+	}
+	if len(slides) == 0 {
+		return "", errors.New("no slides found")
+	}
+	sort.Ints(slides)
+	var out strings.Builder
+	for _, n := range slides {
+		content, err := readZipFile(files, fmt.Sprintf("ppt/slides/slide%d.xml", n))
+		if err != nil {
+			return "", err
+		}
+		text, err := extractOOXMLText(content, "a")
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(fmt.Sprintf("## Slide %d\n\n%s\n\n", n, strings.TrimSpace(text)))
+	}
+	return out.String(), nil
+}
+
+// extractOOXMLText walks WordprocessingML (w:) or DrawingML (a:) markup. Both use p for
+// paragraphs, t for text runs and tbl/tr/tc for tables.
+func extractOOXMLText(data []byte, prefix string) (string, error) {
+	var out strings.Builder
+	var tables []*tableWriter
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		current := func() *strings.Builder {
+			if len(tables) > 0 {
+				return &tables[len(tables)-1].cell
+			}
+			return &out
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if !isOOXMLElement(t.Name, prefix) {
+				continue
+			}
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				current().WriteString("\t")
+			case "br", "cr":
+				current().WriteString("\n")
+			case "tbl":
+				tables = append(tables, &tableWriter{out: current()})
+			}
+		case xml.EndElement:
+			if !isOOXMLElement(t.Name, prefix) {
+				continue
+			}
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if len(tables) > 0 {
+					current().WriteString(" ")
+				} else {
+					out.WriteString("\n")
+				}
+			case "tc":
+				if len(tables) > 0 {
+					tables[len(tables)-1].endCell()
+				}
+			case "tr":
+				if len(tables) > 0 {
+					tables[len(tables)-1].endRow()
+				}
+			case "tbl":
+				if len(tables) > 0 {
+					tables[len(tables)-1].end()
+					tables = tables[:len(tables)-1]
+				}
+			}
+		case xml.CharData:
+			if inText {
+				current().Write(t)
+			}
+		}
+	}
+	return out.String(), nil
+}
+
+func isOOXMLElement(name xml.Name, prefix string) bool {
+	switch prefix {
+	case "w":
+		return strings.HasSuffix(name.Space, "/wordprocessingml/2006/main")
+	case "a":
+		return strings.HasSuffix(name.Space, "/drawingml/2006/main")
+	}
+	return false
+}
+
+// extractXlsx renders every worksheet as a markdown table
+func extractXlsx(data []byte) (string, error) {
+	files, err := openZip(data)
+	if err != nil {
+		return "", err
+	}
+	sharedStrings, err := readSharedStrings(files)
+	if err != nil {
+		return "", err
+	}
+	sheets, err := readWorkbookSheets(files)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	for _, sheet := range sheets {
+		content, err := readZipFile(files, sheet.Path)
+		if err != nil {
+			return "", err
+		}
+		rows, truncated, err := readSheetRows(content, sharedStrings)
+		if err != nil {
+			return "", fmt.Errorf("sheet %s: %w", sheet.Name, err)
+		}
+		out.WriteString("## Sheet: " + sheet.Name + "\n\n")
+		table := &tableWriter{out: &out}
+		for _, row := range rows {
+			for _, cell := range row {
+				table.cell.WriteString(cell)
+				table.endCell()
+			}
+			table.endRow()
+			if out.Len() > maxExtractedText {
+				break
+			}
+		}
+		table.end()
+		if truncated {
+			out.WriteString(fmt.Sprintf("[Sheet truncated after %d rows]\n\n", len(rows)))
+		}
+		if out.Len() > maxExtractedText {
+			break
+		}
+	}
+	return out.String(), nil
+}
+
+type workbookSheet struct {
+	Name string
+	Path string
+}
+
+func readWorkbookSheets(files map[string]*zip.File) ([]workbookSheet, error) {
+	workbook, err := readZipFile(files, "xl/workbook.xml")
+	if err != nil {
+		return nil, err
+	}
+	var wb struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal(workbook, &wb); err != nil {
+		return nil, err
+	}
+	targets := map[string]string{}
+	if rels, err := readZipFile(files, "xl/_rels/workbook.xml.rels"); err == nil {
+		var r struct {
+			Relationships []struct {
+				ID     string `xml:"Id,attr"`
+				Target string `xml:"Target,attr"`
+			} `xml:"Relationship"`
+		}
+		if err := xml.Unmarshal(rels, &r); err != nil {
+			return nil, err
+		}
+		for _, rel := range r.Relationships {
+			target := strings.TrimPrefix(rel.Target, "/")
+			if !strings.HasPrefix(target, "xl/") {
+				target = path.Join("xl", target)
+			}
+			targets[rel.ID] = target
+		}
+	}
+	var sheets []workbookSheet
+	for i, s := range wb.Sheets {
+		sheetPath, ok := targets[s.ID]
+		if !ok {
+			sheetPath = fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)
+		}
+		sheets = append(sheets, workbookSheet{Name: s.Name, Path: sheetPath})
+	}
+	return sheets, nil
+}
+
+func readSharedStrings(files map[string]*zip.File) ([]string, error) {
+	content, err := readZipFile(files, "xl/sharedStrings.xml")
+	if err != nil {
+		// 没有文本单元格的工作簿不包含 sharedStrings.xml
+		return nil, nil
+	}
+	var sst struct {
+		Items []struct {
+			T    string `xml:"t"`
+			Runs []struct {
+				T string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	if err := xml.Unmarshal(content, &sst); err != nil {
+		return nil, err
+	}
+	result := make([]string, len(sst.Items))
+	for i, item := range sst.Items {
+		text := item.T
+		for _, run := range item.Runs {
+			text += run.T
+		}
+		result[i] = text
+	}
+	return result, nil
+}
+
+// readSheetRows returns the cell values of a worksheet, placing cells by their column reference.
+// Cells beyond column XFD are skipped, and rows stop once the padded table would exceed maxSheetCells.
+func readSheetRows(content []byte, sharedStrings []string) ([][]string, bool, error) {
+	var ws struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal(content, &ws); err != nil {
+		return nil, false, err
+	}
+	var rows [][]string
+	width := 0
+	truncated := false
+	for _, row := range ws.Rows {
+		var values []string
+		for i, cell := range row.Cells {
+			col := columnIndex(cell.Ref)
+			if col < 0 {
+				col = i
+			}
+			if col >= maxSheetColumns {
+				continue
+			}
+			for len(values) < col {
+				values = append(values, "")
+			}
+			value := cell.Value
+			switch cell.Type {
+			case "s":
+				if idx, err := strconv.Atoi(cell.Value); err == nil && idx >= 0 && idx < len(sharedStrings) {
+					value = sharedStrings[idx]
+				}
+			case "inlineStr":
+				value = cell.Inline
+			case "b":
+				value = "FALSE"
+				if cell.Value == "1" {
+					value = "TRUE"
+				}
+			}
+			if col < len(values) {
+				values[col] = value
+			} else {
+				values = append(values, value)
+			}
+		}
+		// 跳过完全为空的行
+		if strings.TrimSpace(strings.Join(values, "")) == "" {
+			continue
+		}
+		if len(values) > width {
+			width = len(values)
+		}
+		if (len(rows)+1)*width > maxSheetCells {
+			truncated = true
+			break
+		}
+		rows = append(rows, values)
+	}
+	for i := range rows {
+		for len(rows[i]) < width {
+			rows[i] = append(rows[i], "")
+		}
+	}
+	return rows, truncated, nil
+}
+
+// columnIndex converts a cell reference such as "C7" to a zero based column index.
+// References past column XFD return maxSheetColumns.
+func columnIndex(ref string) int {
+	col := 0
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		if col > maxSheetColumns {
+			return maxSheetColumns
+		}
+		col = col*26 + int(r-'A'+1)
+		n++
+	}
+	if n == 0 {
+		return -1
+	}
+	return col - 1
+}
+
+// htmlBlockElements start a new line in the extracted text
+var htmlBlockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"li": true, "ul": true, "ol": true, "section": true, "article": true, "header": true, "footer": true,
+	"blockquote": true, "pre": true, "hr": true, "dt": true, "dd": true,
+}
+
+// extractHTML returns the visible text of an HTML document, rendering tables as markdown
+func extractHTML(data []byte) (string, error) {
+	var out strings.Builder
+	var tables []*tableWriter
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	skipDepth := 0
+	current := func() *strings.Builder {
+		if len(tables) > 0 {
+			return &tables[len(tables)-1].cell
+		}
+		return &out
+	}
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			if tokenizer.Err() == io.EOF {
+				return collapseBlankLines(out.String()), nil
+			}
+			return "", tokenizer.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			switch tag {
+			case "script", "style", "noscript", "template", "head":
+				if tokenType == html.StartTagToken {
+					skipDepth++
+				}
+				continue
+			case "table":
+				tables = append(tables, &tableWriter{out: current()})
+			case "li":
+				current().WriteString("\n- ")
+				continue
+			case "td", "th":
+				continue
+			}
+			if htmlBlockElements[tag] {
+				current().WriteString("\n")
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			switch tag {
+			case "script", "style", "noscript", "template", "head":
+				if skipDepth > 0 {
+					skipDepth--
+				}
+			case "td", "th":
+				if len(tables) > 0 {
+					tables[len(tables)-1].endCell()
+				}
+			case "tr":
+				if len(tables) > 0 {
+					tables[len(tables)-1].endRow()
+				}
+			case "table":
+				if len(tables) > 0 {
+					tables[len(tables)-1].end()
+					tables = tables[:len(tables)-1]
+				}
+			case "li":
+				// 下一个列表项会另起一行
+			default:
+				if htmlBlockElements[tag] {
+					current().WriteString("\n")
+				}
+			}
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			text := string(tokenizer.Text())
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			current().WriteString(strings.Join(strings.Fields(text), " ") + " ")
+		}
+	}
+}
+
+// collapseBlankLines trims every line and keeps at most one empty line between paragraphs
+func collapseBlankLines(s string) string {
+	var out []string
+	blank := false
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if !blank && len(out) > 0 {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		out = append(out, line)
+		blank = false
+	}
+	return strings.Join(out, "\n")
+}
+
+func openZip(data []byte) (map[string]*zip.File, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid office document: %w", err)
+	}
+	files := make(map[string]*zip.File, len(reader.File))
+	for _, f := range reader.File {
+		files[f.Name] = f
+	}
+	return files, nil
+}
+
+func readZipFile(files map[string]*zip.File, name string) ([]byte, error) {
+	f, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in document", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(io.LimitReader(rc, maxExtractedPartSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxExtractedPartSize {
+		return nil, fmt.Errorf("%s is too large", name)
+	}
+	return content, nil
+}
@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FileError 表示客户端提供的文件无法获取或不受支持，应返回 400 而不是重试
+type FileError struct {
+	Source string
+	Err    error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Source, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+var errPrivateAddress = errors.New("address is not publicly routable")
+
+// IsRemoteURL 判断是否为需要服务端下载的 http(s) 链接
+func IsRemoteURL(s string) bool {
+	lower := strings.ToLower(s)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// FetchRemoteFile 下载远程文件，经过配置的代理，限制大小并拒绝内网地址，返回内容和嗅探出的类型
+func FetchRemoteFile(rawURL string) ([]byte, string, error) {
+	data, contentType, err := fetchRemoteFile(rawURL)
+	if err != nil {
+		return nil, "", &FileError{Source: rawURL, Err: err}
+	}
+	logger.Info(fmt.Sprintf("Fetched remote file %s (%s, %d bytes)", rawURL, contentType, len(data)))
+	return data, contentType, nil
+}
+
+func fetchRemoteFile(rawURL string) ([]byte, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid url: %w", err)
+	}
+	if err := checkFetchURL(u); err != nil {
+		return nil, "", err
+	}
+
+	timeout := time.Duration(config.ConfigInstance.FetchTimeout) * time.Second
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		// 直连时在建立连接前检查解析后的真实地址，防止 DNS rebinding
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%s: %w", host, errPrivateAddress)
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: timeout,
+	}
+	if config.ConfigInstance.Proxy != "" {
+		proxyURL, err := url.Parse(config.ConfigInstance.Proxy)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid proxy: %w", err)
+		}
+		// 代理可能位于内网，走代理时不检查代理本身的地址，目标地址已在 checkFetchURL 中检查
+		transport.Proxy = http.ProxyURL(proxyURL)
+		transport.DialContext = (&net.Dialer{Timeout: 10 * time.Second}).DialContext
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return checkFetchURL(req.URL)
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; claude2api)")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	maxSize := config.ConfigInstance.MaxFileSize
+	if resp.ContentLength > maxSize {
+		return nil, "", fmt.Errorf("file size %d exceeds limit %d", resp.ContentLength, maxSize)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, "", fmt.Errorf("file size exceeds limit %d", maxSize)
+	}
+	if len(data) == 0 {
+		return nil, "", errors.New("empty response body")
+	}
+	contentType := http.DetectContentType(data)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return data, contentType, nil
+}
+
+// checkFetchURL 只允许 http(s)，并拒绝解析到内网、回环和链路本地地址的主机
+func checkFetchURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("missing host")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("%s: %w", host, errPrivateAddress)
+		}
+		return nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("%s resolves to %s: %w", host, ip, errPrivateAddress)
+		}
+	}
+	return nil
+}
+
+// reservedNets 列出 net.IP 方法未覆盖的非公网 IPv4 段
+var reservedNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",     // 本网络
+		"100.64.0.0/10", // 运营商级 NAT
+		"192.0.0.0/24",  // IETF 协议分配
+		"198.18.0.0/15", // 基准测试
+		"240.0.0.0/4",   // 保留及广播地址
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// embeddedIPv4 返回 IPv4 兼容地址、NAT64 (64:ff9b::/96) 和 6to4 (2002::/16) 地址中内嵌的 IPv4 地址
+func embeddedIPv4(ip net.IP) net.IP {
+	ip16 := ip.To16()
+	if ip16 == nil || ip.To4() != nil {
+		return nil
+	}
+	switch {
+	case isZero(ip16[:12]):
+		return net.IP(ip16[12:16])
+	case ip16[0] == 0x00 && ip16[1] == 0x64 && ip16[2] == 0xff && ip16[3] == 0x9b && isZero(ip16[4:12]):
+		return net.IP(ip16[12:16])
+	case ip16[0] == 0x20 && ip16[1] == 0x02:
+		return net.IP(ip16[2:6])
+	}
+	return nil
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func isPublicIP(ip net.IP) bool {
+	if v4 := embeddedIPv4(ip); v4 != nil {
+		ip = v4
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		for _, n := range reservedNets {
+			if n.Contains(ip4) {
+				return false
+			}
+		}
+	}
+	return true
+}
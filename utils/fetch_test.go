@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"claude2api/config"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"8.8.8.8", true},
+		{"2606:4700::1111", true},
+
+		{"127.0.0.1", false},       // loopback
+		{"127.255.0.9", false},     // loopback range
+		{"10.1.2.3", false},        // RFC1918
+		{"172.16.0.1", false},      // RFC1918
+		{"172.31.255.255", false},  // RFC1918
+		{"192.168.1.1", false},     // RFC1918
+		{"169.254.169.254", false}, // link-local, cloud metadata
+		{"100.64.0.1", false},      // CGNAT
+		{"100.127.255.254", false}, // CGNAT
+		{"0.0.0.0", false},         // unspecified
+		{"0.1.2.3", false},         // this network
+		{"192.0.0.170", false},     // IETF protocol assignments
+		{"198.18.0.1", false},      // benchmarking
+		{"255.255.255.255", false}, // broadcast
+		{"240.0.0.1", false},       // reserved
+		{"224.0.0.1", false},       // multicast
+
+		{"::1", false},                    // loopback
+		{"::", false},                     // unspecified
+		{"fc00::1", false},                // ULA
+		{"fd12:3456::1", false},           // ULA
+		{"fe80::1", false},                // link-local
+		{"ff02::1", false},                // multicast
+		{"::ffff:127.0.0.1", false},       // IPv4-mapped loopback
+		{"::ffff:169.254.169.254", false}, // IPv4-mapped metadata
+		{"::ffff:10.0.0.1", false},        // IPv4-mapped RFC1918
+		{"::ffff:93.184.216.34", true},    // IPv4-mapped public
+		{"::127.0.0.1", false},            // IPv4-compatible loopback
+		{"64:ff9b::a9fe:a9fe", false},     // NAT64 metadata
+		{"64:ff9b::5db8:d822", true},      // NAT64 public
+		{"2002:7f00:1::", false},          // 6to4 loopback
+		{"2002:c0a8:101::1", false},       // 6to4 RFC1918
+		{"2002:5db8:d822::1", true},       // 6to4 public
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			ip := net.ParseIP(tt.ip)
+			if ip == nil {
+				t.Fatalf("invalid test address %s", tt.ip)
+			}
+			if got := isPublicIP(ip); got != tt.public {
+				t.Fatalf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.public)
+			}
+		})
+	}
+}
+
+func TestCheckFetchURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		private bool
+		err     bool
+	}{
+		{"http://93.184.216.34/a.png", false, false},
+		{"https://[2606:4700::1111]:8443/a.png", false, false},
+
+		{"http://127.0.0.1/", true, true},
+		{"http://127.0.0.1:8080/admin", true, true},
+		{"http://localhost/", true, true},
+		{"http://10.0.0.1/", true, true},
+		{"http://192.168.0.1/", true, true},
+		{"http://169.254.169.254/latest/meta-data/", true, true},
+		{"http://100.64.0.1/", true, true},
+		{"http://0.0.0.0/", true, true},
+		{"http://[::]/", true, true},
+		{"http://[::1]/", true, true},
+		{"http://[fd00::1]/", true, true},
+		{"http://[fe80::1%25eth0]/", true, true},
+		{"http://[::ffff:127.0.0.1]/", true, true},
+		{"http://[::ffff:a9fe:a9fe]/", true, true},
+
+		{"file:///etc/passwd", false, true},
+		{"ftp://93.184.216.34/a.png", false, true},
+		{"gopher://93.184.216.34/", false, true},
+		{"data:text/plain,hello", false, true},
+		{"//93.184.216.34/a.png", false, true},
+		{"http:///a.png", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = checkFetchURL(u)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			if errors.Is(err, errPrivateAddress) != tt.private {
+				t.Fatalf("got error %v, want private address error %v", err, tt.private)
+			}
+		})
+	}
+}
+
+// TestFetchRemoteFileRedirect serves a public URL through a local proxy that redirects to private
+// hosts, so only the redirect check can stop the request
+func TestFetchRemoteFileRedirect(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata":
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		case "/loopback":
+			http.Redirect(w, r, "http://[::ffff:127.0.0.1]:8080/", http.StatusMovedPermanently)
+		case "/scheme":
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+		case "/public":
+			http.Redirect(w, r, "http://93.184.216.35/file", http.StatusFound)
+		default:
+			w.Write([]byte("hello"))
+		}
+	}))
+	defer proxy.Close()
+
+	cfg := config.ConfigInstance
+	proxyURL, timeout, maxSize := cfg.Proxy, cfg.FetchTimeout, cfg.MaxFileSize
+	cfg.Proxy, cfg.FetchTimeout, cfg.MaxFileSize = proxy.URL, 5, 1<<20
+	defer func() { cfg.Proxy, cfg.FetchTimeout, cfg.MaxFileSize = proxyURL, timeout, maxSize }()
+
+	for _, path := range []string{"/metadata", "/loopback"} {
+		_, _, err := fetchRemoteFile("http://93.184.216.34" + path)
+		if !errors.Is(err, errPrivateAddress) {
+			t.Errorf("redirect %s: got %v, want a private address error", path, err)
+		}
+	}
+	if _, _, err := fetchRemoteFile("http://93.184.216.34/scheme"); err == nil {
+		t.Error("redirect to file:// was followed")
+	}
+	data, _, err := fetchRemoteFile("http://93.184.216.34/public")
+	if err != nil || string(data) != "hello" {
+		t.Errorf("redirect to a public host: got %q, %v", data, err)
+	}
+}
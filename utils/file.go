@@ -0,0 +1,249 @@
+package utils
+
+import (
+	"claude2api/logger"
+	"claude2api/model"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// fileRef 是消息中引用的一个文件，URL 可以是 data URI、http(s) 链接或裸 base64
+type fileRef struct {
+	URL      string
+	FileName string
+	ImageURL bool
+}
+
+// uploadTypes 是可以直接上传到 claude.ai 的文件类型及其默认文件名
+var uploadTypes = map[string]string{
+	"image/jpeg":      "image.jpg",
+	"image/png":       "image.png",
+	"image/webp":      "image.webp",
+	"image/gif":       "image.gif",
+	"application/pdf": "document.pdf",
+}
+
+// textTypes 是按纯文本处理、以 attachments 的 extracted_content 发送的非 text/* 类型
+var textTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-yaml":     true,
+	"application/yaml":       true,
+	"application/x-sh":       true,
+	"application/sql":        true,
+	"application/toml":       true,
+}
+
+// textExtensions 是按扩展名识别的文本文档和源代码
+var textExtensions = map[string]string{
+	".txt": "text/plain", ".md": "text/markdown", ".markdown": "text/markdown", ".csv": "text/csv",
+	".tsv": "text/tab-separated-values", ".json": "application/json", ".jsonl": "application/json",
+	".xml": "application/xml", ".yaml": "application/x-yaml", ".yml": "application/x-yaml",
+	".toml": "application/toml", ".ini": "text/plain", ".log": "text/plain", ".sql": "application/sql",
+	".go": "text/x-go", ".py": "text/x-python", ".js": "application/javascript", ".ts": "text/x-typescript",
+	".jsx": "text/plain", ".tsx": "text/plain", ".java": "text/x-java", ".kt": "text/plain",
+	".c": "text/x-c", ".h": "text/x-c", ".cpp": "text/x-c++", ".hpp": "text/x-c++", ".cs": "text/plain",
+	".rs": "text/x-rust", ".rb": "text/x-ruby", ".php": "text/x-php", ".swift": "text/plain",
+	".sh": "application/x-sh", ".css": "text/css", ".scss": "text/plain", ".vue": "text/plain",
+	".lua": "text/plain", ".r": "text/plain", ".scala": "text/plain", ".dart": "text/plain",
+}
+
+var extensionTypes = map[string]string{
+	".jpg": "image/jpeg", ".jpeg": "image/jpeg", ".png": "image/png", ".webp": "image/webp",
+	".gif": "image/gif", ".pdf": "application/pdf", ".docx": docxType, ".xlsx": xlsxType,
+	".pptx": pptxType, ".html": "text/html", ".htm": "text/html", ".xhtml": "application/xhtml+xml",
+}
+
+// genericTypes are declared or sniffed types that say nothing about the document format
+var genericTypes = map[string]bool{
+	"":                             true,
+	"application/octet-stream":     true,
+	"application/zip":              true,
+	"application/x-zip-compressed": true,
+	"text/plain":                   true,
+}
+
+// IsUploadFile 判断文件是否需要通过 upload 接口上传，否则作为文本 attachment 发送
+func IsUploadFile(file model.FileInput) bool {
+	_, ok := uploadTypes[file.ContentType]
+	return ok
+}
+
+// isTextType 判断内容类型是否按纯文本处理
+func isTextType(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") || textTypes[contentType]
+}
+
+// loadFile 解码或下载文件引用，确定类型和文件名，不支持的类型返回 FileError
+func loadFile(ref fileRef) (model.FileInput, error) {
+	source := ref.FileName
+	if source == "" {
+		source = truncateSource(ref.URL)
+	}
+	var data []byte
+	var contentType string
+	var err error
+	switch {
+	case ref.URL == "":
+		return model.FileInput{}, &FileError{Source: source, Err: errors.New("missing file_data")}
+	case strings.HasPrefix(ref.URL, "file_id:"):
+		return model.FileInput{}, &FileError{Source: ref.URL, Err: errors.New("file_id references are not supported, send file_data instead")}
+	case IsRemoteURL(ref.URL):
+		source = ref.URL
+		if ref.FileName == "" {
+			ref.FileName = remoteFileName(ref.URL)
+		}
+		data, contentType, err = FetchRemoteFile(ref.URL)
+		if err != nil {
+			return model.FileInput{}, err
+		}
+	case strings.HasPrefix(ref.URL, "data:"):
+		data, contentType, err = decodeDataURI(ref.URL)
+	default:
+		// OpenAI 的 file_data 可能是不带 data: 前缀的裸 base64
+		data, err = base64.StdEncoding.DecodeString(ref.URL)
+	}
+	if err != nil {
+		return model.FileInput{}, &FileError{Source: source, Err: err}
+	}
+	if len(data) == 0 {
+		return model.FileInput{}, &FileError{Source: source, Err: errors.New("empty file")}
+	}
+
+	contentType = resolveContentType(contentType, ref.FileName, data)
+	if ref.ImageURL && !strings.HasPrefix(contentType, "image/") && contentType != "application/pdf" {
+		return model.FileInput{}, &FileError{Source: source, Err: fmt.Errorf("content is %s, not an image", contentType)}
+	}
+
+	fileName := ""
+	if ref.FileName != "" {
+		fileName = path.Base(ref.FileName)
+	}
+	if defaultName, ok := uploadTypes[contentType]; ok {
+		if fileName == "" {
+			fileName = defaultName
+		}
+		return model.FileInput{FileName: fileName, ContentType: contentType, Data: data}, nil
+	}
+	if text, ok, err := extractText(contentType, data); ok {
+		if err != nil {
+			return model.FileInput{}, &FileError{Source: source, Err: err}
+		}
+		if fileName == "" {
+			fileName = "document" + extensionFor(contentType)
+		}
+		logger.Debug(fmt.Sprintf("Extracted %d characters from %s", len(text), fileName))
+		return model.FileInput{FileName: fileName, ContentType: contentType, Data: []byte(text)}, nil
+	}
+	if isTextType(contentType) {
+		if !utf8.Valid(data) {
+			return model.FileInput{}, &FileError{Source: source, Err: errors.New("text document is not valid UTF-8")}
+		}
+		if fileName == "" {
+			fileName = "document.txt"
+		}
+		return model.FileInput{FileName: fileName, ContentType: contentType, Data: data}, nil
+	}
+	return model.FileInput{}, &FileError{Source: source, Err: fmt.Errorf("unsupported file type %s", contentType)}
+}
+
+// DocumentText 返回作为纯文本使用的文档内容：docx、xlsx、pptx 和 HTML 提取文本，
+// 文本文件必须是 UTF-8，其他类型返回错误
+func DocumentText(fileName, contentType string, data []byte) (string, error) {
+	contentType = resolveContentType(contentType, fileName, data)
+	if text, ok, err := extractText(contentType, data); ok {
+		return text, err
+	}
+	if isTextType(contentType) {
+		if !utf8.Valid(data) {
+			return "", errors.New("text document is not valid UTF-8")
+		}
+		return string(data), nil
+	}
+	return "", fmt.Errorf("unsupported file type %s", contentType)
+}
+
+// extensionFor returns the file extension registered for a content type
+func extensionFor(contentType string) string {
+	for ext, t := range extensionTypes {
+		if t == contentType && ext != ".htm" {
+			return ext
+		}
+	}
+	return ""
+}
+
+// resolveContentType 优先使用声明的类型，缺失或为通用类型时依次按扩展名和内容嗅探
+func resolveContentType(declared, fileName string, data []byte) string {
+	declared = strings.ToLower(strings.TrimSpace(declared))
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil {
+		declared = mediaType
+	}
+	if declared == "image/jpg" {
+		declared = "image/jpeg"
+	}
+	if !genericTypes[declared] {
+		return declared
+	}
+	ext := strings.ToLower(path.Ext(fileName))
+	if t, ok := extensionTypes[ext]; ok {
+		return t
+	}
+	if t, ok := textExtensions[ext]; ok {
+		return t
+	}
+	if declared != "" && declared != "application/octet-stream" {
+		return declared
+	}
+	sniffed := http.DetectContentType(data)
+	if i := strings.Index(sniffed, ";"); i >= 0 {
+		sniffed = sniffed[:i]
+	}
+	return sniffed
+}
+
+// decodeDataURI 解析 data:<type>;base64,<data> 格式
+func decodeDataURI(dataURI string) ([]byte, string, error) {
+	parts := strings.SplitN(dataURI, ",", 2)
+	if len(parts) != 2 {
+		return nil, "", errors.New("invalid file data format")
+	}
+	meta := strings.TrimPrefix(parts[0], "data:")
+	if !strings.HasSuffix(meta, ";base64") {
+		return nil, "", errors.New("invalid encoding in file data")
+	}
+	contentType := strings.TrimSuffix(meta, ";base64")
+	data, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode base64 data: %w", err)
+	}
+	return data, contentType, nil
+}
+
+// remoteFileName 取链接路径的最后一段作为文件名
+func remoteFileName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+func truncateSource(s string) string {
+	if len(s) > 48 {
+		return s[:48] + "..."
+	}
+	return s
+}
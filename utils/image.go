@@ -0,0 +1,279 @@
+package utils
+
+import (
+	"bytes"
+	"claude2api/config"
+	"claude2api/logger"
+	"claude2api/model"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var imageFormatTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+var imageTypeExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// maxDecodePixels is the largest image decoded for processing. The header alone can declare
+// huge dimensions, so larger images are passed through before any pixel buffer is allocated.
+const maxDecodePixels = 40_000_000
+
+// ProcessImage downscales and re-encodes an image according to opts. The original is
+// returned when it is already within limits, apart from EXIF being stripped from JPEGs.
+func ProcessImage(file model.FileInput, opts config.ImageProcessing) model.FileInput {
+	if !opts.Enabled || !strings.HasPrefix(file.ContentType, "image/") {
+		return file
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(file.Data))
+	if err != nil {
+		logger.Debug(fmt.Sprintf("Skip image processing for %s: %v", file.FileName, err))
+		return file
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxDecodePixels || cfg.Width <= 0 || cfg.Height <= 0 {
+		logger.Info(fmt.Sprintf("Skip image processing for %s: %dx%d exceeds the decode limit", file.FileName, cfg.Width, cfg.Height))
+		return file
+	}
+	if format == "gif" && isAnimatedGIF(file.Data) {
+		logger.Debug(fmt.Sprintf("Skip image processing for animated gif %s", file.FileName))
+		return file
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = jpegOrientation(file.Data)
+	}
+	width, height := cfg.Width, cfg.Height
+	if orientation >= 5 {
+		width, height = height, width
+	}
+	newWidth, newHeight := fitImage(width, height, opts.MaxEdge, opts.MaxPixels)
+	targetType := imageFormatTypes[opts.Format]
+	if targetType == "" {
+		targetType = file.ContentType
+		if _, ok := imageTypeExtensions[targetType]; !ok {
+			// gif 等无法原样编码的格式重编码为 png
+			targetType = "image/png"
+		}
+	}
+
+	withinLimits := newWidth == width && newHeight == height && orientation == 1
+	if withinLimits && targetType == file.ContentType {
+		if format == "jpeg" {
+			stripped := stripJPEGMetadata(file.Data)
+			if len(stripped) != len(file.Data) {
+				logger.Debug(fmt.Sprintf("Stripped EXIF from %s: %d -> %d bytes", file.FileName, len(file.Data), len(stripped)))
+				file.Data = stripped
+			}
+		}
+		return file
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(file.Data))
+	if err != nil {
+		logger.Debug(fmt.Sprintf("Skip image processing for %s: %v", file.FileName, err))
+		return file
+	}
+	img = applyOrientation(img, orientation)
+	if newWidth != width || newHeight != height {
+		dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+		img = dst
+	}
+
+	var buf bytes.Buffer
+	switch targetType {
+	case "image/jpeg":
+		quality := opts.Quality
+		if quality <= 0 || quality > 100 {
+			quality = 85
+		}
+		err = jpeg.Encode(&buf, flattenAlpha(img), &jpeg.Options{Quality: quality})
+	case "image/webp":
+		err = nativewebp.Encode(&buf, img, nil)
+	default:
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to encode image %s: %v", file.FileName, err))
+		return file
+	}
+
+	logger.Debug(fmt.Sprintf("Processed image %s: %dx%d %s %d bytes -> %dx%d %s %d bytes",
+		file.FileName, cfg.Width, cfg.Height, file.ContentType, len(file.Data),
+		newWidth, newHeight, targetType, buf.Len()))
+	fileName := file.FileName
+	if targetType != file.ContentType {
+		fileName = strings.TrimSuffix(fileName, path.Ext(fileName)) + imageTypeExtensions[targetType]
+	}
+	return model.FileInput{FileName: fileName, ContentType: targetType, Data: buf.Bytes()}
+}
+
+// fitImage returns the largest size keeping the aspect ratio within maxEdge and maxPixels
+func fitImage(width, height, maxEdge, maxPixels int) (int, int) {
+	scale := 1.0
+	if maxEdge > 0 && (width > maxEdge || height > maxEdge) {
+		scale = math.Min(float64(maxEdge)/float64(width), float64(maxEdge)/float64(height))
+	}
+	if maxPixels > 0 && float64(width)*float64(height)*scale*scale > float64(maxPixels) {
+		scale = math.Sqrt(float64(maxPixels) / (float64(width) * float64(height)))
+	}
+	if scale >= 1 {
+		return width, height
+	}
+	return max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+}
+
+func isAnimatedGIF(data []byte) bool {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	return err == nil && len(g.Image) > 1
+}
+
+// flattenAlpha draws the image on a white background since JPEG has no alpha channel
+func flattenAlpha(img image.Image) image.Image {
+	if _, ok := img.(*image.YCbCr); ok {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+// stripJPEGMetadata removes APPn segments (EXIF, XMP, ...) and comments, keeping JFIF, ICC and Adobe
+func stripJPEGMetadata(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return data
+		}
+		marker := data[i+1]
+		if marker == 0xDA {
+			// 扫描数据开始，后面的内容原样保留
+			return append(out, data[i:]...)
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			return data
+		}
+		segment := data[i : i+2+length]
+		// 保留 APP0 JFIF、APP2 ICC 色彩配置和 APP14 Adobe
+		isAPP := marker >= 0xE1 && marker <= 0xEF && marker != 0xE2 && marker != 0xEE
+		if !isAPP && marker != 0xFE {
+			out = append(out, segment...)
+		}
+		i += 2 + length
+	}
+	return data
+}
+
+// jpegOrientation reads the EXIF orientation tag, returning 1 when absent
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 14 && string(segment[:6]) == "Exif\x00\x00" {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	for n := 0; n < count; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// applyOrientation rotates and flips the image so that it displays upright without EXIF
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
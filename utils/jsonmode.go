@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"bytes"
+	"claude2api/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const jsonObjectPrompt = "Respond with a single valid JSON object only. Do not wrap it in markdown code fences, do not create artifacts and do not write anything before or after the JSON.\n\n"
+
+const jsonSchemaPrompt = "Respond with a single valid JSON value only, conforming to the JSON Schema below. Do not wrap it in markdown code fences, do not create artifacts and do not write anything before or after the JSON.\n<json_schema name=%q>\n%s\n</json_schema>\n\n"
+
+const jsonRepairPrompt = "Your previous reply could not be used: %s. Reply again with only the corrected JSON, nothing else.\n\n"
+
+var (
+	thinkBlock = regexp.MustCompile(`(?s)<think>.*?</think>\n?`)
+	codeFence  = regexp.MustCompile("(?s)```[a-zA-Z0-9_/+-]*[ \\t]*\\n(.*?)\\n?```")
+)
+
+// IsJSONMode reports whether the response format asks for JSON output
+func IsJSONMode(format *model.ResponseFormat) bool {
+	return format != nil && (format.Type == "json_object" || format.Type == "json_schema")
+}
+
+// ValidateResponseFormat checks the response_format of a request
+func ValidateResponseFormat(format *model.ResponseFormat) error {
+	if format == nil {
+		return nil
+	}
+	switch format.Type {
+	case "", "text", "json_object":
+		return nil
+	case "json_schema":
+		if format.JSONSchema == nil || format.JSONSchema.Schema == nil {
+			return errors.New("response_format.json_schema.schema is required")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown response_format type %q", format.Type)
+	}
+}
+
+// JSONInstruction returns the instruction appended to the prompt in JSON mode
+func JSONInstruction(format *model.ResponseFormat) string {
+	if format.Type == "json_schema" && format.JSONSchema != nil {
+		schema, _ := json.MarshalIndent(format.JSONSchema.Schema, "", "  ")
+		instruction := fmt.Sprintf(jsonSchemaPrompt, format.JSONSchema.Name, schema)
+		if format.JSONSchema.Description != "" {
+			instruction = format.JSONSchema.Description + "\n" + instruction
+		}
+		return instruction
+	}
+	return jsonObjectPrompt
+}
+
+// JSONRepairInstruction asks the model to correct its previous answer
+func JSONRepairInstruction(err error) string {
+	return fmt.Sprintf(jsonRepairPrompt, err)
+}
+
+// CheckJSONResponse extracts the JSON value from a model answer and validates it against the
+// response format. It returns the compact JSON text.
+func CheckJSONResponse(format *model.ResponseFormat, text string) (string, error) {
+	raw, err := ExtractJSON(text)
+	if err != nil {
+		return "", err
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return "", fmt.Errorf("invalid JSON: %v", err)
+	}
+	if format.Type == "json_schema" && format.JSONSchema != nil {
+		if errs := ValidateJSONSchema(format.JSONSchema.Schema, value); len(errs) > 0 {
+			return "", fmt.Errorf("JSON does not match the schema: %s", strings.Join(errs, "; "))
+		}
+	} else if _, ok := value.(map[string]interface{}); !ok {
+		return "", fmt.Errorf("expected a JSON object, got %s", jsonType(value))
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(raw)); err != nil {
+		return "", fmt.Errorf("invalid JSON: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// ExtractJSON finds the JSON value in a model answer, skipping thinking blocks, code fences
+// (including artifacts rendered as fences) and surrounding prose
+func ExtractJSON(text string) (string, error) {
+	text = strings.TrimSpace(thinkBlock.ReplaceAllString(text, ""))
+	if json.Valid([]byte(text)) && text != "" {
+		return text, nil
+	}
+	for _, match := range codeFence.FindAllStringSubmatch(text, -1) {
+		body := strings.TrimSpace(match[1])
+		if json.Valid([]byte(body)) {
+			return body, nil
+		}
+		if candidate, ok := scanJSON(body); ok {
+			return candidate, nil
+		}
+	}
+	if candidate, ok := scanJSON(text); ok {
+		return candidate, nil
+	}
+	return "", errors.New("no JSON value found in the reply")
+}
+
+// scanJSON returns the first balanced {...} or [...] that is valid JSON
+func scanJSON(text string) (string, bool) {
+	for start := 0; start < len(text); start++ {
+		if text[start] != '{' && text[start] != '[' {
+			continue
+		}
+		if end := matchBracket(text, start); end > 0 && json.Valid([]byte(text[start:end])) {
+			return text[start:end], true
+		}
+	}
+	return "", false
+}
+
+// matchBracket returns the index after the bracket closing the one at start, or -1
+func matchBracket(text string, start int) int {
+	depth := 0
+	inString := false
+	escaped := false
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"claude2api/model"
+	"strings"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+		err  bool
+	}{
+		{"plain object", `{"a": 1}`, `{"a": 1}`, false},
+		{"plain array", ` [1, 2] `, `[1, 2]`, false},
+		{"json fence", "```json\n{\"a\": 1}\n```", `{"a": 1}`, false},
+		{"bare fence", "```\n[1]\n```", `[1]`, false},
+		{"fence after prose", "Here it is:\n```json\n{\"a\": \"}\"}\n```\nDone.", `{"a": "}"}`, false},
+		{"artifact fence with prose inside", "\n```md\nResult: {\"a\": 1} as requested\n```\n", `{"a": 1}`, false},
+		{"prefixed", `Sure! {"a": {"b": [1, 2]}} Hope this helps.`, `{"a": {"b": [1, 2]}}`, false},
+		{"thinking skipped", "<think> maybe {\"draft\": true}</think>\n{\"a\": 1}", `{"a": 1}`, false},
+		{"braces in strings", `x {"a": "{[\"", "b": 1} y`, `{"a": "{[\"", "b": 1}`, false},
+		{"invalid candidate skipped", `{not json} then {"a": 1}`, `{"a": 1}`, false},
+		{"unterminated object", `{"a": 1, "b": [1, 2`, "", true},
+		{"unterminated string", `{"a": "b}`, "", true},
+		{"unterminated fence", "```json\n{\"a\": 1}", `{"a": 1}`, false},
+		{"no json", "I cannot do that.", "", true},
+		{"empty", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractJSON(tt.text)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckJSONResponse(t *testing.T) {
+	schema := &model.ResponseFormat{Type: "json_schema", JSONSchema: &model.JSONSchemaFormat{
+		Name:   "person",
+		Schema: map[string]interface{}{"type": "object", "required": []interface{}{"name"}},
+	}}
+	object := &model.ResponseFormat{Type: "json_object"}
+	tests := []struct {
+		name   string
+		format *model.ResponseFormat
+		text   string
+		want   string
+		err    string
+	}{
+		{"object compacted", object, "```json\n{ \"a\" : 1 }\n```", `{"a":1}`, ""},
+		{"object mode rejects arrays", object, `[1]`, "", "expected a JSON object, got array"},
+		{"schema pass", schema, `{"name": "Ann"}`, `{"name":"Ann"}`, ""},
+		{"schema fail", schema, `{"age": 3}`, "", `missing required property "name"`},
+		{"no json", schema, `sorry`, "", "no JSON value found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CheckJSONResponse(tt.format, tt.text)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
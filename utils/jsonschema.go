@@ -0,0 +1,413 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSchemaErrors limits the errors reported back to the model
+const maxSchemaErrors = 10
+
+// maxSchemaSteps bounds the schema nodes visited for one value, so nested anyOf/oneOf or
+// recursive $ref cannot make validation exponential
+const maxSchemaSteps = 20000
+
+// ValidateJSONSchema validates a decoded JSON value against a JSON Schema and returns the
+// violations. The commonly used subset of draft 2020-12 is supported: type, enum, const,
+// properties, required, additionalProperties, patternProperties, items, prefixItems,
+// min/max constraints, pattern, allOf/anyOf/oneOf/not, nullable and local $ref.
+func ValidateJSONSchema(schema map[string]interface{}, value interface{}) []string {
+	v := &schemaValidator{root: schema, state: &schemaState{
+		regexps: map[string]*regexp.Regexp{},
+		refs:    map[string]bool{},
+	}}
+	v.validate(schema, value, "$", 0)
+	if v.state.exhausted {
+		return []string{"$: schema too complex to validate"}
+	}
+	return v.errors
+}
+
+type schemaValidator struct {
+	root   map[string]interface{}
+	errors []string
+	state  *schemaState
+}
+
+// schemaState is shared with the validators of anyOf/oneOf/not branches
+type schemaState struct {
+	steps     int
+	exhausted bool
+	// compiled patterns, nil for invalid ones
+	regexps map[string]*regexp.Regexp
+	// $ref being applied at a path, a reference reached again without consuming input is a cycle
+	refs map[string]bool
+}
+
+// regexp compiles a pattern once per validation
+func (s *schemaState) regexp(pattern string) *regexp.Regexp {
+	re, ok := s.regexps[pattern]
+	if !ok {
+		re, _ = regexp.Compile(pattern)
+		s.regexps[pattern] = re
+	}
+	return re
+}
+
+func (v *schemaValidator) fail(path, format string, args ...interface{}) {
+	if len(v.errors) < maxSchemaErrors {
+		v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// sub validates into a separate validator, used by anyOf/oneOf/not
+func (v *schemaValidator) sub(schema interface{}, value interface{}, path string, depth int) []string {
+	s := &schemaValidator{root: v.root, state: v.state}
+	s.validateAny(schema, value, path, depth)
+	return s.errors
+}
+
+func (v *schemaValidator) validateAny(schema interface{}, value interface{}, path string, depth int) {
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			v.fail(path, "not allowed")
+		}
+	case map[string]interface{}:
+		v.validate(s, value, path, depth)
+	}
+}
+
+func (v *schemaValidator) validate(schema map[string]interface{}, value interface{}, path string, depth int) {
+	if v.state.exhausted {
+		return
+	}
+	if v.state.steps++; v.state.steps > maxSchemaSteps {
+		v.state.exhausted = true
+		return
+	}
+	if depth > 64 {
+		v.fail(path, "schema nesting too deep")
+		return
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := v.resolve(ref)
+		if err != nil {
+			v.fail(path, "%v", err)
+			return
+		}
+		key := ref + "\x00" + path
+		if v.state.refs[key] {
+			v.fail(path, "$ref %s is a cycle", ref)
+			return
+		}
+		v.state.refs[key] = true
+		v.validateAny(target, value, path, depth+1)
+		delete(v.state.refs, key)
+	}
+
+	if nullable, _ := schema["nullable"].(bool); nullable && value == nil {
+		return
+	}
+	if t, ok := schema["type"]; ok && !matchesType(t, value) {
+		v.fail(path, "expected %s, got %s", typeNames(t), jsonType(value))
+		return
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, item := range enum {
+			if jsonEqual(item, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, "must be one of %s", compactJSON(enum))
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, value) {
+		v.fail(path, "must be %s", compactJSON(c))
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(schema, val, path, depth)
+	case []interface{}:
+		v.validateArray(schema, val, path, depth)
+	case string:
+		v.validateString(schema, val, path)
+	case float64:
+		v.validateNumber(schema, val, path)
+	}
+
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, s := range allOf {
+			v.validateAny(s, value, path, depth+1)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		matched := false
+		var first []string
+		for _, s := range anyOf {
+			if v.state.exhausted {
+				return
+			}
+			errs := v.sub(s, value, path, depth+1)
+			if len(errs) == 0 {
+				matched = true
+				break
+			}
+			if first == nil {
+				first = errs
+			}
+		}
+		if !matched {
+			v.fail(path, "does not match any allowed schema (%s)", strings.Join(first, "; "))
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		count := 0
+		for _, s := range oneOf {
+			if v.state.exhausted {
+				return
+			}
+			if len(v.sub(s, value, path, depth+1)) == 0 {
+				count++
+			}
+		}
+		if count != 1 {
+			v.fail(path, "must match exactly one schema, matched %d", count)
+		}
+	}
+	if not, ok := schema["not"]; ok && len(v.sub(not, value, path, depth+1)) == 0 {
+		v.fail(path, "must not match schema")
+	}
+}
+
+func (v *schemaValidator) validateObject(schema map[string]interface{}, obj map[string]interface{}, path string, depth int) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, exists := obj[name]; !exists {
+					v.fail(path, "missing required property %q", name)
+				}
+			}
+		}
+	}
+	if n, ok := number(schema["minProperties"]); ok && float64(len(obj)) < n {
+		v.fail(path, "must have at least %v properties", n)
+	}
+	if n, ok := number(schema["maxProperties"]); ok && float64(len(obj)) > n {
+		v.fail(path, "must have at most %v properties", n)
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	patterns, _ := schema["patternProperties"].(map[string]interface{})
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		childPath := path + "." + key
+		matched := false
+		if s, ok := properties[key]; ok {
+			matched = true
+			v.validateAny(s, obj[key], childPath, depth+1)
+		}
+		for pattern, s := range patterns {
+			if re := v.state.regexp(pattern); re != nil && re.MatchString(key) {
+				matched = true
+				v.validateAny(s, obj[key], childPath, depth+1)
+			}
+		}
+		if matched {
+			continue
+		}
+		if additional, ok := schema["additionalProperties"]; ok {
+			if allowed, isBool := additional.(bool); isBool && !allowed {
+				v.fail(path, "unexpected property %q", key)
+			} else {
+				v.validateAny(additional, obj[key], childPath, depth+1)
+			}
+		}
+	}
+}
+
+func (v *schemaValidator) validateArray(schema map[string]interface{}, arr []interface{}, path string, depth int) {
+	if n, ok := number(schema["minItems"]); ok && float64(len(arr)) < n {
+		v.fail(path, "must have at least %v items", n)
+	}
+	if n, ok := number(schema["maxItems"]); ok && float64(len(arr)) > n {
+		v.fail(path, "must have at most %v items", n)
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if jsonEqual(arr[i], arr[j]) {
+					v.fail(path, "items %d and %d are equal", i, j)
+				}
+			}
+		}
+	}
+	start := 0
+	if prefix, ok := schema["prefixItems"].([]interface{}); ok {
+		for i, s := range prefix {
+			if i < len(arr) {
+				v.validateAny(s, arr[i], fmt.Sprintf("%s[%d]", path, i), depth+1)
+			}
+		}
+		start = len(prefix)
+	}
+	switch items := schema["items"].(type) {
+	case []interface{}:
+		// draft-07 tuple form
+		for i, s := range items {
+			if i < len(arr) {
+				v.validateAny(s, arr[i], fmt.Sprintf("%s[%d]", path, i), depth+1)
+			}
+		}
+	case nil:
+	default:
+		for i := start; i < len(arr); i++ {
+			v.validateAny(items, arr[i], fmt.Sprintf("%s[%d]", path, i), depth+1)
+		}
+	}
+}
+
+func (v *schemaValidator) validateString(schema map[string]interface{}, s string, path string) {
+	length := float64(utf8.RuneCountInString(s))
+	if n, ok := number(schema["minLength"]); ok && length < n {
+		v.fail(path, "must be at least %v characters", n)
+	}
+	if n, ok := number(schema["maxLength"]); ok && length > n {
+		v.fail(path, "must be at most %v characters", n)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if re := v.state.regexp(pattern); re != nil && !re.MatchString(s) {
+			v.fail(path, "must match pattern %s", pattern)
+		}
+	}
+}
+
+func (v *schemaValidator) validateNumber(schema map[string]interface{}, n float64, path string) {
+	if min, ok := number(schema["minimum"]); ok && n < min {
+		v.fail(path, "must be >= %v", min)
+	}
+	if max, ok := number(schema["maximum"]); ok && n > max {
+		v.fail(path, "must be <= %v", max)
+	}
+	if min, ok := number(schema["exclusiveMinimum"]); ok && n <= min {
+		v.fail(path, "must be > %v", min)
+	}
+	if max, ok := number(schema["exclusiveMaximum"]); ok && n >= max {
+		v.fail(path, "must be < %v", max)
+	}
+	if m, ok := number(schema["multipleOf"]); ok && m > 0 {
+		if q := n / m; math.Abs(q-math.Round(q)) > 1e-9 {
+			v.fail(path, "must be a multiple of %v", m)
+		}
+	}
+}
+
+// resolve looks up a local reference such as #/$defs/Item or #/definitions/Item
+func (v *schemaValidator) resolve(ref string) (interface{}, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %s", ref)
+	}
+	var current interface{} = v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %s", ref)
+		}
+		if current, ok = m[part]; !ok {
+			return nil, fmt.Errorf("unresolvable $ref %s", ref)
+		}
+	}
+	return current, nil
+}
+
+func matchesType(t interface{}, value interface{}) bool {
+	switch types := t.(type) {
+	case string:
+		return matchesTypeName(types, value)
+	case []interface{}:
+		for _, name := range types {
+			if s, ok := name.(string); ok && matchesTypeName(s, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesTypeName(name string, value interface{}) bool {
+	switch name {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return jsonType(value) == name
+	}
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func typeNames(t interface{}) string {
+	if types, ok := t.([]interface{}); ok {
+		names := make([]string, 0, len(types))
+		for _, name := range types {
+			names = append(names, fmt.Sprint(name))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func number(v interface{}) (float64, bool) {
+	n, ok := v.(float64)
+	return n, ok
+}
+
+func jsonEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func compactJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, text string) interface{} {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		t.Fatalf("invalid JSON %s: %v", text, err)
+	}
+	return value
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		// substring of the first error, empty when the value must be valid
+		err string
+	}{
+		{"type pass", `{"type": "string"}`, `"a"`, ""},
+		{"type fail", `{"type": "string"}`, `1`, "expected string, got number"},
+		{"type list pass", `{"type": ["string", "null"]}`, `null`, ""},
+		{"type list fail", `{"type": ["string", "null"]}`, `true`, "expected string or null, got boolean"},
+		{"integer pass", `{"type": "integer"}`, `3`, ""},
+		{"integer fail", `{"type": "integer"}`, `3.5`, "expected integer"},
+		{"nullable pass", `{"type": "string", "nullable": true}`, `null`, ""},
+		{"enum pass", `{"enum": ["a", 1]}`, `1`, ""},
+		{"enum fail", `{"enum": ["a", 1]}`, `"b"`, `must be one of ["a",1]`},
+		{"const pass", `{"const": {"a": 1}}`, `{"a": 1}`, ""},
+		{"const fail", `{"const": {"a": 1}}`, `{"a": 2}`, `must be {"a":1}`},
+
+		{"required pass", `{"required": ["a"]}`, `{"a": 1}`, ""},
+		{"required fail", `{"required": ["a"]}`, `{}`, `missing required property "a"`},
+		{"properties pass", `{"properties": {"a": {"type": "number"}}}`, `{"a": 1}`, ""},
+		{"properties fail", `{"properties": {"a": {"type": "number"}}}`, `{"a": "x"}`, "$.a: expected number"},
+		{"additionalProperties false", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1, "b": 2}`, `unexpected property "b"`},
+		{"additionalProperties schema pass", `{"additionalProperties": {"type": "string"}}`, `{"b": "x"}`, ""},
+		{"additionalProperties schema fail", `{"additionalProperties": {"type": "string"}}`, `{"b": 1}`, "$.b: expected string"},
+		{"patternProperties pass", `{"patternProperties": {"^x_": {"type": "number"}}, "additionalProperties": false}`, `{"x_a": 1}`, ""},
+		{"patternProperties fail", `{"patternProperties": {"^x_": {"type": "number"}}, "additionalProperties": false}`, `{"x_a": "1"}`, "$.x_a: expected number"},
+		{"minProperties fail", `{"minProperties": 2}`, `{"a": 1}`, "at least 2 properties"},
+		{"maxProperties fail", `{"maxProperties": 1}`, `{"a": 1, "b": 2}`, "at most 1 properties"},
+
+		{"items pass", `{"items": {"type": "number"}}`, `[1, 2]`, ""},
+		{"items fail", `{"items": {"type": "number"}}`, `[1, "2"]`, "$[1]: expected number"},
+		{"prefixItems pass", `{"prefixItems": [{"type": "string"}], "items": {"type": "number"}}`, `["a", 1]`, ""},
+		{"prefixItems fail", `{"prefixItems": [{"type": "string"}], "items": {"type": "number"}}`, `[1, 1]`, "$[0]: expected string"},
+		{"tuple items fail", `{"items": [{"type": "string"}, {"type": "number"}]}`, `["a", "b"]`, "$[1]: expected number"},
+		{"minItems fail", `{"minItems": 2}`, `[1]`, "at least 2 items"},
+		{"maxItems fail", `{"maxItems": 1}`, `[1, 2]`, "at most 1 items"},
+		{"uniqueItems pass", `{"uniqueItems": true}`, `[1, 2]`, ""},
+		{"uniqueItems fail", `{"uniqueItems": true}`, `[{"a": 1}, {"a": 1}]`, "items 0 and 1 are equal"},
+
+		{"minLength counts runes", `{"minLength": 2}`, `"é"`, "at least 2 characters"},
+		{"maxLength pass", `{"maxLength": 2}`, `"éé"`, ""},
+		{"maxLength fail", `{"maxLength": 2}`, `"abc"`, "at most 2 characters"},
+		{"pattern pass", `{"pattern": "^[a-z]+$"}`, `"abc"`, ""},
+		{"pattern fail", `{"pattern": "^[a-z]+$"}`, `"ABC"`, "must match pattern"},
+
+		{"minimum fail", `{"minimum": 1}`, `0`, "must be >= 1"},
+		{"maximum fail", `{"maximum": 1}`, `2`, "must be <= 1"},
+		{"exclusiveMinimum fail", `{"exclusiveMinimum": 1}`, `1`, "must be > 1"},
+		{"exclusiveMaximum fail", `{"exclusiveMaximum": 1}`, `1`, "must be < 1"},
+		{"multipleOf pass", `{"multipleOf": 0.1}`, `0.3`, ""},
+		{"multipleOf fail", `{"multipleOf": 2}`, `3`, "multiple of 2"},
+
+		{"allOf fail", `{"allOf": [{"type": "number"}, {"minimum": 5}]}`, `3`, "must be >= 5"},
+		{"anyOf pass", `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, `1`, ""},
+		{"anyOf fail", `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, `true`, "does not match any allowed schema"},
+		{"oneOf pass", `{"oneOf": [{"type": "string"}, {"type": "number"}]}`, `1`, ""},
+		{"oneOf fail", `{"oneOf": [{"type": "number"}, {"minimum": 0}]}`, `1`, "matched 2"},
+		{"not pass", `{"not": {"type": "string"}}`, `1`, ""},
+		{"not fail", `{"not": {"type": "string"}}`, `"a"`, "must not match schema"},
+		{"false schema", `{"properties": {"a": false}}`, `{"a": 1}`, "$.a: not allowed"},
+
+		{"nested $ref pass", `{"$defs": {"item": {"properties": {"tags": {"$ref": "#/$defs/tags"}}}, "tags": {"items": {"type": "string"}}}, "items": {"$ref": "#/$defs/item"}}`, `[{"tags": ["a"]}]`, ""},
+		{"nested $ref fail", `{"$defs": {"item": {"properties": {"tags": {"$ref": "#/$defs/tags"}}}, "tags": {"items": {"type": "string"}}}, "items": {"$ref": "#/$defs/item"}}`, `[{"tags": [1]}]`, "$[0].tags[0]: expected string"},
+		{"recursive $ref consuming input", `{"$defs": {"node": {"properties": {"next": {"$ref": "#/$defs/node"}, "v": {"type": "number"}}}}, "$ref": "#/$defs/node"}`, `{"v": 1, "next": {"v": 2, "next": {"v": "x"}}}`, "$.next.next.v: expected number"},
+		{"definitions $ref", `{"definitions": {"a/b": {"type": "string"}}, "$ref": "#/definitions/a~1b"}`, `1`, "expected string"},
+		{"unresolvable $ref", `{"$ref": "#/$defs/missing"}`, `1`, "unresolvable $ref #/$defs/missing"},
+		{"remote $ref", `{"$ref": "https://example.com/schema.json"}`, `1`, "unsupported $ref"},
+		{"$ref cycle", `{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`, `1`, "$ref #/$defs/a is a cycle"},
+		{"$ref to root cycle", `{"anyOf": [{"$ref": "#"}]}`, `1`, "is a cycle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := decodeJSON(t, tt.schema).(map[string]interface{})
+			errs := ValidateJSONSchema(schema, decodeJSON(t, tt.value))
+			if tt.err == "" {
+				if len(errs) > 0 {
+					t.Fatalf("unexpected errors: %v", errs)
+				}
+				return
+			}
+			if len(errs) == 0 {
+				t.Fatalf("expected an error containing %q", tt.err)
+			}
+			if !strings.Contains(strings.Join(errs, "; "), tt.err) {
+				t.Fatalf("errors %v do not contain %q", errs, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateJSONSchemaErrorLimit(t *testing.T) {
+	schema := decodeJSON(t, `{"items": {"type": "string"}}`).(map[string]interface{})
+	errs := ValidateJSONSchema(schema, decodeJSON(t, `[1,2,3,4,5,6,7,8,9,10,11,12]`))
+	if len(errs) != maxSchemaErrors {
+		t.Fatalf("got %d errors, want %d", len(errs), maxSchemaErrors)
+	}
+}
+
+// TestValidateJSONSchemaStepLimit nests anyOf branches that all fail, which would visit 2^30
+// schemas without the step budget
+func TestValidateJSONSchemaStepLimit(t *testing.T) {
+	defs := map[string]interface{}{}
+	for i := 0; i < 30; i++ {
+		next := map[string]interface{}{"$ref": fmt.Sprintf("#/$defs/d%d", i+1)}
+		defs[fmt.Sprintf("d%d", i)] = map[string]interface{}{"anyOf": []interface{}{next, next}}
+	}
+	defs["d30"] = map[string]interface{}{"type": "string"}
+	schema := map[string]interface{}{"$defs": defs, "$ref": "#/$defs/d0"}
+
+	errs := ValidateJSONSchema(schema, 1.0)
+	if len(errs) != 1 || errs[0] != "$: schema too complex to validate" {
+		t.Fatalf("got %v, want the step limit error", errs)
+	}
+	// the same schema is cheap for a matching value
+	if errs := ValidateJSONSchema(schema, "a"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
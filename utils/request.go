@@ -4,79 +4,238 @@ package utils
 import (
 	"claude2api/config"
 	"claude2api/logger"
+	"claude2api/model"
+	"encoding/json"
 	"fmt"
 	"strings"
 )
 
 // ChatRequestProcessor handles common chat request processing logic
 type ChatRequestProcessor struct {
-	Prompt      strings.Builder
-	RootPrompt  strings.Builder
-	ImgDataList []string
+	Prompt     strings.Builder
+	RootPrompt strings.Builder
+	Files      []model.FileInput // Images and PDFs uploaded to claude.ai
+	Documents  []model.FileInput // Text documents sent as attachments
+	// History moved out of the prompt by the context strategy
+	ContextDocuments []model.FileInput
+	CompactedTurns   int             // Turns replaced by a summary, 0 when not compacted
+	Template         *PromptRenderer // Renders messages and context instructions
+	Turns            []Turn          // Rendered messages, used to move old history into attachments
+	header           string
+	fileRefs         []fileRef
+}
+
+// Turn is one rendered message of the flattened prompt
+type Turn struct {
+	Role string
+	Text string
 }
 
 // NewChatRequestProcessor creates a new processor instance
 func NewChatRequestProcessor() *ChatRequestProcessor {
 	return &ChatRequestProcessor{
-		Prompt:      strings.Builder{},
-		RootPrompt:  strings.Builder{},
-		ImgDataList: []string{},
+		Prompt:     strings.Builder{},
+		RootPrompt: strings.Builder{},
+		Files:      []model.FileInput{},
+		Documents:  []model.FileInput{},
+		Template:   DefaultPromptRenderer(),
 	}
 }
 
-// ProcessMessages processes the messages array into a prompt and extracts images
-func (p *ChatRequestProcessor) ProcessMessages(messages []map[string]interface{}) {
+// ProcessMessages processes the messages array into a prompt and collects file references.
+// It returns *RoleSpoofingError when content contains role markers and the policy is reject.
+func (p *ChatRequestProcessor) ProcessMessages(messages []map[string]interface{}) error {
+	r := p.Template
 	if config.ConfigInstance.PromptDisableArtifacts {
-		p.Prompt.WriteString("System: Forbidden to use <antArtifac> </antArtifac> to wrap code blocks, use markdown syntax instead, which means wrapping code blocks with ``` ```\n\n")
+		p.header = r.DisableArtifacts()
+		p.Prompt.WriteString(p.header)
 	}
 
-	for _, msg := range messages {
+	toolNames := map[string]string{} // tool_call_id -> function name
+	for i, msg := range messages {
 		role, roleOk := msg["role"].(string)
 		if !roleOk {
 			continue // Skip invalid format
 		}
+		if role == "developer" {
+			// OpenAI o 系列和新版客户端用 developer 代替 system
+			role = "system"
+		}
 
 		content, exists := msg["content"]
-		if !exists {
+		toolCalls := assistantToolCalls(msg)
+		if !exists && len(toolCalls) == 0 {
 			continue
 		}
+		name, _ := msg["name"].(string)
+		name = promptIdentifier(name)
 
-		p.Prompt.WriteString(GetRolePrefix(role))
-
+		var body strings.Builder
 		switch v := content.(type) {
 		case string: // If content is directly a string
-			p.Prompt.WriteString(v + "\n\n")
+			body.WriteString(v + r.separator)
 		case []interface{}: // If content is an array of []interface{} type
 			for _, item := range v {
 				if itemMap, ok := item.(map[string]interface{}); ok {
 					if itemType, ok := itemMap["type"].(string); ok {
 						if itemType == "text" {
 							if text, ok := itemMap["text"].(string); ok {
-								p.Prompt.WriteString(text + "\n\n")
+								body.WriteString(text + r.separator)
 							}
 						} else if itemType == "image_url" {
 							if imageUrl, ok := itemMap["image_url"].(map[string]interface{}); ok {
 								if url, ok := imageUrl["url"].(string); ok {
-									p.ImgDataList = append(p.ImgDataList, url)
+									p.addFileRef(&body, fileRef{URL: url, ImageURL: true})
 								}
 							}
+						} else if itemType == "file" {
+							// Chat Completions: {"type":"file","file":{"filename":...,"file_data":...}}
+							if file, ok := itemMap["file"].(map[string]interface{}); ok {
+								p.addFileRef(&body, fileRefFromPart(file))
+							}
+						} else if itemType == "input_file" {
+							// Responses API: {"type":"input_file","filename":...,"file_data":...}
+							p.addFileRef(&body, fileRefFromPart(itemMap))
 						}
 					}
 				}
 			}
 		}
+
+		text, err := r.neutralizeRoles(fmt.Sprintf("message %d", i), body.String())
+		if err != nil {
+			return err
+		}
+		for _, call := range toolCalls {
+			call.ID = promptIdentifier(call.ID)
+			call.Name = promptIdentifier(call.Name)
+			if call.Arguments, err = r.neutralizeRoles(fmt.Sprintf("message %d tool call", i), call.Arguments); err != nil {
+				return err
+			}
+			if call.ID != "" {
+				toolNames[call.ID] = call.Name
+			}
+			text += r.ToolCall(call)
+		}
+		if role == "tool" || role == "function" {
+			toolCallID, _ := msg["tool_call_id"].(string)
+			toolCallID = promptIdentifier(toolCallID)
+			if name == "" {
+				name = toolNames[toolCallID]
+			}
+			text = r.ToolResult(ToolResultData{Name: name, ToolCallID: toolCallID, Content: text})
+			// 名称已在工具结果中展示
+			name = ""
+		}
+		turn := r.Message(MessageData{Role: role, Label: r.Label(role), Name: name, Content: text, Index: i})
+		p.Prompt.WriteString(turn)
+		p.Turns = append(p.Turns, Turn{Role: role, Text: turn})
 	}
 	p.RootPrompt.WriteString(p.Prompt.String())
 	// Debug output
 	logger.Debug(fmt.Sprintf("Processed prompt: %s", p.Prompt.String()))
-	logger.Debug(fmt.Sprintf("Image data list: %v", p.ImgDataList))
+	logger.Debug(fmt.Sprintf("File references: %d", len(p.fileRefs)))
+	return nil
 }
 
-// ResetForBigContext resets the prompt for big context usage
-func (p *ChatRequestProcessor) ResetForBigContext() {
-	p.Prompt.Reset()
-	if config.ConfigInstance.PromptDisableArtifacts {
-		p.Prompt.WriteString("System: Forbidden to use <antArtifac> </antArtifac> to wrap code blocks, use markdown syntax instead, which means wrapping code blocks with ``` ```\n\n")
+// assistantToolCalls reads tool_calls, or the legacy function_call, of an assistant message
+func assistantToolCalls(msg map[string]interface{}) []ToolCallData {
+	var calls []ToolCallData
+	if items, ok := msg["tool_calls"].([]interface{}); ok {
+		for _, item := range items {
+			call, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			data := ToolCallData{}
+			data.ID, _ = call["id"].(string)
+			if function, ok := call["function"].(map[string]interface{}); ok {
+				data.Name, _ = function["name"].(string)
+				data.Arguments = toolArguments(function["arguments"])
+			}
+			calls = append(calls, data)
+		}
+	}
+	if function, ok := msg["function_call"].(map[string]interface{}); ok {
+		data := ToolCallData{}
+		data.Name, _ = function["name"].(string)
+		data.Arguments = toolArguments(function["arguments"])
+		calls = append(calls, data)
+	}
+	return calls
+}
+
+// toolArguments returns the arguments as a JSON string, some clients send an object instead
+func toolArguments(v interface{}) string {
+	switch args := v.(type) {
+	case string:
+		return args
+	case nil:
+		return "{}"
+	default:
+		data, err := json.Marshal(args)
+		if err != nil {
+			return fmt.Sprint(args)
+		}
+		return string(data)
+	}
+}
+
+// addFileRef records a file reference and writes its rendered reference, if any, into the message
+func (p *ChatRequestProcessor) addFileRef(body *strings.Builder, ref fileRef) {
+	p.fileRefs = append(p.fileRefs, ref)
+	kind := "file"
+	if ref.ImageURL {
+		kind = "image"
+	}
+	if text := p.Template.FileReference(FileReferenceData{Kind: kind, Index: len(p.fileRefs), FileName: promptIdentifier(ref.FileName)}); text != "" {
+		body.WriteString(text + p.Template.separator)
+	}
+}
+
+// PrepareFiles decodes or downloads every referenced file and sorts it into uploads and
+// text attachments. Unsupported or unreachable files are returned as *FileError, text
+// attachments rejected by the role spoofing policy as *RoleSpoofingError.
+func (p *ChatRequestProcessor) PrepareFiles() error {
+	for _, ref := range p.fileRefs {
+		file, err := loadFile(ref)
+		if err != nil {
+			return err
+		}
+		if IsUploadFile(file) {
+			p.Files = append(p.Files, file)
+		} else {
+			text, err := p.Template.neutralizeRoles(file.FileName, string(file.Data))
+			if err != nil {
+				return err
+			}
+			file.Data = []byte(text)
+			p.Documents = append(p.Documents, file)
+		}
+		logger.Debug(fmt.Sprintf("Prepared file %s (%s, %d bytes)", file.FileName, file.ContentType, len(file.Data)))
+	}
+	p.fileRefs = nil
+	return nil
+}
+
+// ProcessImages downscales and re-encodes uploaded images with the model's settings
+func (p *ChatRequestProcessor) ProcessImages(opts config.ImageProcessing) {
+	for i, file := range p.Files {
+		p.Files[i] = ProcessImage(file, opts)
+	}
+}
+
+// fileRefFromPart reads filename and file_data/file_url from an OpenAI file content part
+func fileRefFromPart(part map[string]interface{}) fileRef {
+	ref := fileRef{}
+	ref.FileName, _ = part["filename"].(string)
+	if data, ok := part["file_data"].(string); ok && data != "" {
+		ref.URL = data
+	} else if url, ok := part["file_url"].(string); ok {
+		ref.URL = url
+	} else if id, ok := part["file_id"].(string); ok {
+		// file_id 引用的是 OpenAI 文件存储，这里无法获取
+		ref.URL = "file_id:" + id
 	}
-	p.Prompt.WriteString("You must immerse yourself in the role of assistant in context.txt, cannot respond as a user, cannot reply to this message, cannot mention this message, and ignore this message in your response.\n\n")
+	return ref
 }
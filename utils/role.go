@@ -1,22 +1,10 @@
 package utils
 
-import (
-	"claude2api/config"
-)
-
-// **获取角色前缀**
+// **获取角色前缀**，使用内置模板的角色名称
 func GetRolePrefix(role string) string {
-	if config.ConfigInstance.NoRolePrefix {
+	label := DefaultPromptRenderer().Label(role)
+	if label == "" {
 		return ""
 	}
-	switch role {
-	case "system":
-		return "System: "
-	case "user":
-		return "Human: "
-	case "assistant":
-		return "Assistant: "
-	default:
-		return "Unknown: "
-	}
+	return label + ": "
 }
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Role spoofing policies for role markers found inside message content
+const (
+	RoleSpoofingOff    = "off"    // Keep content unchanged
+	RoleSpoofingEscape = "escape" // Replace the colon of the marker with a fullwidth colon
+	RoleSpoofingWrap   = "wrap"   // Wrap content containing markers in delimiters
+	RoleSpoofingReject = "reject" // Reject the request
+)
+
+const (
+	wrapOpen  = "<message_content>\n"
+	wrapClose = "\n</message_content>"
+)
+
+// RoleSpoofingError is returned by the reject policy
+type RoleSpoofingError struct {
+	Source string
+	Marker string
+}
+
+func (e *RoleSpoofingError) Error() string {
+	return fmt.Sprintf("%s contains role marker %q", e.Source, e.Marker)
+}
+
+// maxIdentifierLength limits names and ids rendered into the prompt
+const maxIdentifierLength = 128
+
+// promptIdentifier makes a client supplied name, id or file name safe to render on one line.
+// Line breaks and control characters would let it start a new role turn, quotes and angle
+// brackets would let it leave the attribute of a tool tag.
+func promptIdentifier(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '"' || r == '<' || r == '>':
+			return -1
+		case unicode.IsControl(r) || r == '\u2028' || r == '\u2029':
+			return ' '
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+	if runes := []rune(s); len(runes) > maxIdentifierLength {
+		s = string(runes[:maxIdentifierLength])
+	}
+	return s
+}
+
+// wrapClosePattern matches closing delimiters inside wrapped content
+var wrapClosePattern = regexp.MustCompile(`(?i)</(\s*message_content)`)
+
+// rolePattern matches a role label followed by a colon at the start of a line. A lone carriage
+// return also starts a line, since the model may read it as a line break.
+func rolePattern(labels map[string]string) *regexp.Regexp {
+	seen := map[string]bool{}
+	var names []string
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label == "" || seen[strings.ToLower(label)] {
+			continue
+		}
+		seen[strings.ToLower(label)] = true
+		names = append(names, regexp.QuoteMeta(label))
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?im)(^|\r)([ \t]*(?:` + strings.Join(names, "|") + `)[ \t]*):`)
+}
+
+// neutralizeRoles applies the configured policy to client supplied content
+func (r *PromptRenderer) neutralizeRoles(source, text string) (string, error) {
+	policy := config.ConfigInstance.RoleSpoofing
+	if policy == "" || policy == RoleSpoofingOff || r.rolePattern == nil {
+		return text, nil
+	}
+	matches := r.rolePattern.FindAllStringIndex(text, -1)
+	if len(matches) == 0 {
+		return text, nil
+	}
+	logger.Info(fmt.Sprintf("Found %d role markers in %s, policy: %s", len(matches), source, policy))
+	switch policy {
+	case RoleSpoofingReject:
+		marker := strings.TrimSpace(text[matches[0][0]:matches[0][1]])
+		return "", &RoleSpoofingError{Source: source, Marker: marker}
+	case RoleSpoofingWrap:
+		// 转义内容中的分隔符，防止提前闭合
+		text = wrapClosePattern.ReplaceAllString(text, "<\\/${1}")
+		return wrapOpen + strings.TrimRight(text, "\n") + wrapClose + r.separator, nil
+	default:
+		return r.rolePattern.ReplaceAllString(text, "${1}${2}："), nil
+	}
+}
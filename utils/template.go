@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"claude2api/config"
+	"claude2api/logger"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// defaultPromptTemplate reproduces the built-in Human:/Assistant: prompt format
+var defaultPromptTemplate = config.PromptTemplate{
+	Message: "{{if .Label}}{{.Label}}{{if .Name}} ({{.Name}}){{end}}: {{end}}{{.Content}}",
+	RoleLabels: map[string]string{
+		"system":    "System",
+		"user":      "Human",
+		"assistant": "Assistant",
+		// 工具结果来自用户一侧
+		"tool":     "Human",
+		"function": "Human",
+		"unknown":  "Unknown",
+	},
+	ToolCall: "<tool_call{{if .ID}} id=\"{{.ID}}\"{{end}} name=\"{{.Name}}\">\n{{.Arguments}}\n</tool_call>\n\n",
+	ToolResult: "<tool_result{{if .Name}} name=\"{{.Name}}\"{{end}}{{if .ToolCallID}} tool_call_id=\"{{.ToolCallID}}\"{{end}}>\n" +
+		"{{trim .Content}}\n</tool_result>\n\n",
+	DisableArtifacts: "System: Forbidden to use <antArtifac> </antArtifac> to wrap code blocks, use markdown syntax instead, which means wrapping code blocks with ``` ```\n\n",
+	BigContext: "You must immerse yourself in the role of assistant in " +
+		"{{if eq (len .Files) 1}}{{index .Files 0}}{{else}}the attached context files in order{{end}}, " +
+		"cannot respond as a user, cannot reply to this message, cannot mention this message, and ignore this message in your response.\n\n",
+	RecentContext: "The earlier part of this conversation is in the attached {{join .Files \", \"}}. " +
+		"Treat it as previous turns of this conversation, continue as the assistant, and do not mention the attachment unless asked.\n\n",
+	CompactedContext: "[Context compacted: {{.Turns}} earlier turns were replaced by the summary below]\n" +
+		"<conversation_summary>\n{{.Summary}}\n</conversation_summary>\n\n",
+}
+
+const defaultSeparator = "\n\n"
+
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+}
+
+// PromptRenderer renders messages and context instructions with a compiled PromptTemplate
+type PromptRenderer struct {
+	Name             string
+	separator        string
+	labels           map[string]string
+	rolePattern      *regexp.Regexp
+	message          *template.Template
+	fileReference    *template.Template
+	toolCall         *template.Template
+	toolResult       *template.Template
+	disableArtifacts *template.Template
+	bigContext       *template.Template
+	recentContext    *template.Template
+	compactedContext *template.Template
+}
+
+// MessageData is passed to the message template
+type MessageData struct {
+	Role    string
+	Label   string
+	Name    string
+	Content string
+	Index   int
+}
+
+// FileReferenceData is passed to the file reference template
+type FileReferenceData struct {
+	Kind     string // image or file
+	Index    int    // 1-based position among all files of the request
+	FileName string
+}
+
+// ToolCallData is passed to the tool call template
+type ToolCallData struct {
+	ID        string
+	Name      string
+	Arguments string // JSON encoded arguments
+}
+
+// ToolResultData is passed to the tool result template
+type ToolResultData struct {
+	Name       string
+	ToolCallID string
+	Content    string
+}
+
+var (
+	renderers       sync.Map
+	defaultRenderer *PromptRenderer
+)
+
+func init() {
+	r, err := NewPromptRenderer("", config.PromptTemplate{})
+	if err != nil {
+		panic(err)
+	}
+	defaultRenderer = r
+}
+
+// DefaultPromptRenderer returns the renderer for the built-in prompt format
+func DefaultPromptRenderer() *PromptRenderer {
+	return defaultRenderer
+}
+
+// PromptRendererFor returns the compiled renderer of the template selected for the API key
+// template and model. Invalid templates are logged and replaced by the built-in format.
+func PromptRendererFor(keyTemplate, model string) *PromptRenderer {
+	name, tmpl := config.ConfigInstance.PromptTemplateFor(keyTemplate, model)
+	if name == "" {
+		return defaultRenderer
+	}
+	if r, ok := renderers.Load(name); ok {
+		return r.(*PromptRenderer)
+	}
+	r, err := NewPromptRenderer(name, tmpl)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Invalid prompt template %s, using default: %v", name, err))
+		return defaultRenderer
+	}
+	renderers.Store(name, r)
+	return r
+}
+
+// NewPromptRenderer compiles a template, empty fields fall back to the built-in format
+func NewPromptRenderer(name string, tmpl config.PromptTemplate) (*PromptRenderer, error) {
+	r := &PromptRenderer{Name: name, separator: defaultSeparator, labels: map[string]string{}}
+	if tmpl.Separator != nil {
+		r.separator = *tmpl.Separator
+	}
+	for role, label := range defaultPromptTemplate.RoleLabels {
+		r.labels[role] = label
+	}
+	for role, label := range tmpl.RoleLabels {
+		r.labels[role] = label
+	}
+	r.rolePattern = rolePattern(r.labels)
+
+	fields := []struct {
+		dst      **template.Template
+		name     string
+		text     string
+		fallback string
+	}{
+		{&r.message, "message", tmpl.Message, defaultPromptTemplate.Message},
+		{&r.fileReference, "fileReference", tmpl.FileReference, defaultPromptTemplate.FileReference},
+		{&r.toolCall, "toolCall", tmpl.ToolCall, defaultPromptTemplate.ToolCall},
+		{&r.toolResult, "toolResult", tmpl.ToolResult, defaultPromptTemplate.ToolResult},
+		{&r.disableArtifacts, "disableArtifacts", tmpl.DisableArtifacts, defaultPromptTemplate.DisableArtifacts},
+		{&r.bigContext, "bigContext", tmpl.BigContext, defaultPromptTemplate.BigContext},
+		{&r.recentContext, "recentContext", tmpl.RecentContext, defaultPromptTemplate.RecentContext},
+		{&r.compactedContext, "compactedContext", tmpl.CompactedContext, defaultPromptTemplate.CompactedContext},
+	}
+	for _, field := range fields {
+		text := field.text
+		if text == "" {
+			text = field.fallback
+		}
+		t, err := template.New(field.name).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field.name, err)
+		}
+		*field.dst = t
+	}
+	return r, nil
+}
+
+// execute renders a template, logging errors and returning what was rendered so far
+func execute(t *template.Template, data interface{}) string {
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		logger.Error(fmt.Sprintf("Failed to render prompt template %s: %v", t.Name(), err))
+	}
+	return sb.String()
+}
+
+// Label returns the role label, empty when role prefixes are disabled
+func (r *PromptRenderer) Label(role string) string {
+	if config.ConfigInstance.NoRolePrefix {
+		return ""
+	}
+	if label, ok := r.labels[role]; ok {
+		return label
+	}
+	return r.labels["unknown"]
+}
+
+// Message renders one message
+func (r *PromptRenderer) Message(data MessageData) string {
+	return execute(r.message, data)
+}
+
+// FileReference renders the reference to a file, empty when not configured
+func (r *PromptRenderer) FileReference(data FileReferenceData) string {
+	return execute(r.fileReference, data)
+}
+
+// ToolCall renders a tool call of an assistant message
+func (r *PromptRenderer) ToolCall(data ToolCallData) string {
+	return execute(r.toolCall, data)
+}
+
+// ToolResult renders the content of a tool or function message
+func (r *PromptRenderer) ToolResult(data ToolResultData) string {
+	return execute(r.toolResult, data)
+}
+
+// DisableArtifacts renders the instruction placed before the prompt
+func (r *PromptRenderer) DisableArtifacts() string {
+	return execute(r.disableArtifacts, nil)
+}
+
+// BigContext renders the instruction used when the whole history is attached
+func (r *PromptRenderer) BigContext(files []string) string {
+	return execute(r.bigContext, map[string]interface{}{"Files": files})
+}
+
+// RecentContext renders the instruction pointing to older turns in attachments
+func (r *PromptRenderer) RecentContext(files []string) string {
+	return execute(r.recentContext, map[string]interface{}{"Files": files})
+}
+
+// CompactedContext renders the summary replacing older turns
+func (r *PromptRenderer) CompactedContext(turns int, summary string) string {
+	return execute(r.compactedContext, map[string]interface{}{"Turns": turns, "Summary": summary})
+}